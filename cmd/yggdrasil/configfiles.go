@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/hjson/hjson-go"
+	"golang.org/x/text/encoding/unicode"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+
+	"github.com/popura-network/Popura/src/popura"
+)
+
+// A configuration can be split over several files, which are merged in the
+// order that they are read. -useconffile accepts a directory, in which case
+// every *.conf file in it is read in lexical order, and any file can list
+// further files, directories or glob patterns to read after it in its
+// Include option. Relative paths, in Include and in PrivateKeyPath, are
+// relative to the directory of the file that sets them.
+//
+// When merging, objects are merged key by key and the lists of peers in Peers
+// and InterfacePeers are concatenated with duplicates removed. Any other
+// value, including any other list, replaces the one read before it.
+
+// configFile is a single configuration file that has been read and parsed.
+type configFile struct {
+	source string
+	dir    string                 // directory that relative paths are relative to
+	data   []byte                 // contents, decoded to UTF-8
+	dat    map[string]interface{} // parsed contents, without Include
+}
+
+// readConfigPath reads the configuration file at path, or every *.conf file
+// in it if it is a directory, along with the files that they include.
+// including lists the absolute paths of the files that led to path being
+// read, so that include cycles can be detected.
+func readConfigPath(path string, including []string) ([]*configFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, &configReadError{path, err}
+	}
+	if !info.IsDir() {
+		return readConfigFile(path, including)
+	}
+	paths, err := filepath.Glob(filepath.Join(path, "*.conf"))
+	if err != nil {
+		return nil, &configReadError{path, err}
+	}
+	if len(paths) == 0 {
+		return nil, &configReadError{path, errors.New("directory contains no *.conf files")}
+	}
+	var files []*configFile
+	for _, p := range paths {
+		read, err := readConfigFile(p, including)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, read...)
+	}
+	return files, nil
+}
+
+func readConfigFile(path string, including []string) ([]*configFile, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, &configReadError{path, err}
+	}
+	for _, p := range including {
+		if p == abs {
+			return nil, &configReadError{path, errors.New("include cycle")}
+		}
+	}
+	conf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &configReadError{path, err}
+	}
+	return parseConfigFile(path, conf, filepath.Dir(path), append(including, abs))
+}
+
+// parseConfigFile parses the configuration conf read from source, followed by
+// the files that it includes relative to dir.
+func parseConfigFile(source string, conf []byte, dir string, including []string) ([]*configFile, error) {
+	if len(bytes.TrimSpace(conf)) == 0 {
+		return nil, &configReadError{source, errors.New("config is empty")}
+	}
+	// If there's a byte order mark - which Windows 10 is now incredibly fond of
+	// throwing everywhere when it's converting things into UTF-16 for the hell
+	// of it - remove it and decode back down into UTF-8. This is necessary
+	// because hjson doesn't know what to do with UTF-16 and will panic
+	if bytes.HasPrefix(conf, []byte{0xFF, 0xFE}) ||
+		bytes.HasPrefix(conf, []byte{0xFE, 0xFF}) {
+		utf := unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
+		decoder := utf.NewDecoder()
+		var err error
+		if conf, err = decoder.Bytes(conf); err != nil {
+			return nil, &configReadError{source, err}
+		}
+	}
+	var dat map[string]interface{}
+	if err := hjson.Unmarshal(conf, &dat); err != nil {
+		return nil, &configReadError{source, err}
+	}
+	files := []*configFile{{source: source, dir: dir, data: conf, dat: dat}}
+
+	include, ok := dat["Include"]
+	if !ok {
+		return files, nil
+	}
+	delete(dat, "Include")
+	patterns, ok := include.([]interface{})
+	if !ok {
+		return nil, &configReadError{source, errors.New("Include must be a list of paths")}
+	}
+	for i, p := range patterns {
+		pattern, ok := p.(string)
+		if !ok {
+			return nil, &configReadError{source, fmt.Errorf("Include[%d] must be a string", i)}
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		// Paths without wildcards must exist, but a pattern is allowed to
+		// match nothing, e.g. an empty drop-in directory.
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, &configReadError{source, fmt.Errorf("Include[%d]: %w", i, err)}
+			}
+		}
+		for _, match := range matches {
+			read, err := readConfigPath(match, including)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, read...)
+		}
+	}
+	return files, nil
+}
+
+// mergeConfig merges the parsed configuration src into dst.
+func mergeConfig(dst, src map[string]interface{}) {
+	mergeSection(dst, src, nil)
+}
+
+// mergeSection merges src into dst, which are the object at path in the
+// configuration.
+func mergeSection(dst, src map[string]interface{}, path []string) {
+	for key, value := range src {
+		keyPath := append(path[:len(path):len(path)], key)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			d, ok := dst[key].(map[string]interface{})
+			if !ok {
+				d = make(map[string]interface{})
+				dst[key] = d
+			}
+			mergeSection(d, v, keyPath)
+		case []interface{}:
+			if !isPeerList(keyPath) {
+				dst[key] = v
+				break
+			}
+			d, _ := dst[key].([]interface{})
+			dst[key] = appendUnique(d, v)
+		default:
+			dst[key] = v
+		}
+	}
+}
+
+// isPeerList reports whether the option at path is a list of peers, which is
+// concatenated with the lists read before it rather than replacing them.
+func isPeerList(path []string) bool {
+	switch len(path) {
+	case 1:
+		return path[0] == "Peers"
+	case 2:
+		return path[0] == "InterfacePeers"
+	}
+	return false
+}
+
+func appendUnique(dst, src []interface{}) []interface{} {
+	if dst == nil {
+		dst = []interface{}{}
+	}
+next:
+	for _, v := range src {
+		for _, d := range dst {
+			if reflect.DeepEqual(d, v) {
+				continue next
+			}
+		}
+		dst = append(dst, v)
+	}
+	return dst
+}
+
+// marshalConfig marshals the effective configuration as HJSON, or as JSON if
+// asJSON is set.
+func marshalConfig(cfg *config.NodeConfig, popConfig *popura.PopuraConfig, asJSON bool) ([]byte, error) {
+	conf := effectiveConfig(cfg, popConfig)
+	if asJSON {
+		return json.MarshalIndent(conf, "", "  ")
+	}
+	return hjson.Marshal(conf)
+}
+
+// effectiveConfig returns a struct with the fields of cfg, in order and with
+// their comments, followed by the Popura section. A private key that is stored
+// in a file is written out as PrivateKeyPath, which NodeConfig doesn't have a
+// field for, in place of PrivateKey.
+func effectiveConfig(cfg *config.NodeConfig, popConfig *popura.PopuraConfig) interface{} {
+	v := reflect.ValueOf(cfg).Elem()
+	var fields []reflect.StructField
+	var values []reflect.Value
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		if field.Name == "PrivateKey" && popConfig.PrivateKeyPath != "" {
+			field = reflect.StructField{
+				Name: "PrivateKeyPath",
+				Type: reflect.TypeOf(""),
+				Tag:  `comment:"File containing your private key. DO NOT share it with anyone!"`,
+			}
+			value = reflect.ValueOf(popConfig.PrivateKeyPath)
+		}
+		fields = append(fields, reflect.StructField{Name: field.Name, Type: field.Type, Tag: field.Tag})
+		values = append(values, value)
+	}
+	fields = append(fields, reflect.StructField{
+		Name: "Popura",
+		Type: reflect.TypeOf(popConfig),
+		Tag:  `comment:"Settings for the features that Popura adds to Yggdrasil."`,
+	})
+	values = append(values, reflect.ValueOf(popConfig))
+	conf := reflect.New(reflect.StructOf(fields)).Elem()
+	for i, value := range values {
+		conf.Field(i).Set(value)
+	}
+	return conf.Interface()
+}
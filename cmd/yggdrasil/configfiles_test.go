@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hjson/hjson-go"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/defaults"
+
+	"github.com/popura-network/Popura/src/popura"
+)
+
+func TestMergeConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"value replaced", []string{`{IfName: "a"}`, `{IfName: "b"}`}, `{IfName: "b"}`},
+		{"peers concatenated", []string{`{Peers: ["a", "b"]}`, `{Peers: ["b", "c"]}`}, `{Peers: ["a", "b", "c"]}`},
+		{"peers added to nothing", []string{`{IfName: "a"}`, `{Peers: ["a"]}`}, `{IfName: "a", Peers: ["a"]}`},
+		{"no peers added", []string{`{Peers: ["a"]}`, `{Peers: []}`}, `{Peers: ["a"]}`},
+		{"interface peers concatenated", []string{`{InterfacePeers: {eth0: ["a"]}}`, `{InterfacePeers: {eth0: ["b"], eth1: ["c"]}}`}, `{InterfacePeers: {eth0: ["a", "b"], eth1: ["c"]}}`},
+		{"list replaced", []string{`{Listen: ["a", "b"]}`, `{Listen: ["c"]}`}, `{Listen: ["c"]}`},
+		{"list emptied", []string{`{Listen: ["a"]}`, `{Listen: []}`}, `{Listen: []}`},
+		{"objects merged", []string{`{NodeInfo: {a: 1, b: 2}}`, `{NodeInfo: {b: 3}}`}, `{NodeInfo: {a: 1, b: 3}}`},
+		{"nested list replaced", []string{`{NodeInfo: {a: ["a"]}}`, `{NodeInfo: {a: ["b"]}}`}, `{NodeInfo: {a: ["b"]}}`},
+		{"nested Peers replaced", []string{`{Popura: {Peers: ["a"]}}`, `{Popura: {Peers: ["b"]}}`}, `{Popura: {Peers: ["b"]}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dat := make(map[string]interface{})
+			for _, f := range test.files {
+				var src map[string]interface{}
+				if err := hjson.Unmarshal([]byte(f), &src); err != nil {
+					t.Fatal(err)
+				}
+				mergeConfig(dat, src)
+			}
+			var want map[string]interface{}
+			if err := hjson.Unmarshal([]byte(test.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dat, want) {
+				t.Errorf("got %v, want %v", dat, want)
+			}
+		})
+	}
+}
+
+func TestMarshalConfig(t *testing.T) {
+	cfg := defaults.GenerateConfig()
+	cfg.Peers = []string{"tls://192.0.2.1:443"}
+	popConfig := popura.GenerateConfig()
+	popConfig.PrivateKeyPath = "/etc/popura/key"
+	for _, asJSON := range []bool{false, true} {
+		bs, err := marshalConfig(cfg, popConfig, asJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dat map[string]interface{}
+		if err := hjson.Unmarshal(bs, &dat); err != nil {
+			t.Fatalf("JSON %v: %v", asJSON, err)
+		}
+		if _, ok := dat["PrivateKey"]; ok {
+			t.Errorf("JSON %v: a key in a file was written out", asJSON)
+		}
+		if dat["PrivateKeyPath"] != popConfig.PrivateKeyPath {
+			t.Errorf("JSON %v: got PrivateKeyPath %v", asJSON, dat["PrivateKeyPath"])
+		}
+		if !reflect.DeepEqual(dat["Peers"], []interface{}{cfg.Peers[0]}) {
+			t.Errorf("JSON %v: got Peers %v", asJSON, dat["Peers"])
+		}
+		if _, ok := dat["Popura"].(map[string]interface{}); !ok {
+			t.Errorf("JSON %v: got no Popura section", asJSON)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hjson/hjson-go"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+// Every configuration option can be overridden by an environment variable.
+// Top-level options are named POPURA_<OPTION> and options in the Popura
+// section POPURA_<SECTION>_<OPTION>, all in upper case, e.g. POPURA_PEERS or
+// POPURA_MESHNAME_ENABLE. Lists of strings are given comma separated, and
+// other lists and objects in HJSON. The private key can only be given in
+// popura.PrivateKeyEnv, which isn't named after an option.
+
+const envPrefix = "POPURA_"
+
+// applyEnv overrides options of cfg and popConfig with the environment
+// variables in environ, as returned by os.Environ.
+func applyEnv(environ []string, cfg *config.NodeConfig, popConfig *popura.PopuraConfig, logger *logging.Logger) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.IndexByte(kv, '='); i > 0 && strings.HasPrefix(kv[:i], envPrefix) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	delete(env, popura.PrivateKeyEnv)
+	if err := applyEnvStruct(env, envPrefix, reflect.ValueOf(cfg).Elem()); err != nil {
+		return err
+	}
+	if err := applyEnvStruct(env, envPrefix, reflect.ValueOf(popConfig).Elem()); err != nil {
+		return err
+	}
+	// Whatever is left over doesn't name an option, which is probably a typo.
+	unknown := make([]string, 0, len(env))
+	for name := range env {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		logger.Warnln("Ignoring unknown environment variable", name)
+	}
+	return nil
+}
+
+// applyEnvStruct sets the fields of the struct v from the environment
+// variables in env that are named after them, and removes those from env.
+func applyEnvStruct(env map[string]string, prefix string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		switch field.Name {
+		case "PrivateKey", "PublicKey":
+			continue
+		}
+		name := prefix + strings.ToUpper(field.Name)
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnvStruct(env, name+"_", v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := env[name]
+		if !ok {
+			continue
+		}
+		delete(env, name)
+		if err := setFromEnv(v.Field(i), value); err != nil {
+			return &configError{name, err}
+		}
+	}
+	return nil
+}
+
+func setFromEnv(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String &&
+			!strings.HasPrefix(strings.TrimSpace(s), "[") {
+			list := []string{}
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			v.Set(reflect.ValueOf(list))
+			return nil
+		}
+		// Decode through JSON like the configuration file, so that options
+		// are matched by name in the same way.
+		var dat interface{}
+		if err := hjson.Unmarshal([]byte(s), &dat); err != nil {
+			return err
+		}
+		bs, err := json.Marshal(dat)
+		if err != nil {
+			return err
+		}
+		value := reflect.New(v.Type())
+		if err := json.Unmarshal(bs, value.Interface()); err != nil {
+			return fmt.Errorf("expected %s: %w", v.Type(), err)
+		}
+		v.Set(value.Elem())
+	}
+	return nil
+}
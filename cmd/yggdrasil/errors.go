@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes of the daemon. These are documented in the README and must not
+// change, as service supervisors use them to tell configuration problems
+// apart from runtime failures.
+const (
+	exitSuccess       = 0
+	exitFailure       = 1 // unclassified failure
+	exitUsage         = 2 // invalid or missing command line options
+	exitConfigRead    = 3 // the configuration could not be read or parsed
+	exitConfigInvalid = 4 // the configuration contains an invalid value
+	exitSetup         = 5 // a subsystem failed to start
+)
+
+// usageError is returned when the command line doesn't say what to do.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+// configReadError is returned when the configuration can't be read or parsed.
+type configReadError struct {
+	source string
+	err    error
+}
+
+func (e *configReadError) Error() string {
+	return fmt.Sprintf("failed to read config from %s: %v", e.source, e.err)
+}
+
+func (e *configReadError) Unwrap() error { return e.err }
+
+// configError is returned when a configuration value is invalid. The key
+// names the offending option, e.g. "PrivateKey" or "AllowedPublicKeys[2]".
+type configError struct {
+	key string
+	err error
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("invalid config value %s: %v", e.key, e.err)
+}
+
+func (e *configError) Unwrap() error { return e.err }
+
+// setupError is returned when a subsystem of the node fails to start.
+type setupError struct {
+	subsystem string
+	err       error
+}
+
+func (e *setupError) Error() string {
+	return fmt.Sprintf("failed to start %s: %v", e.subsystem, e.err)
+}
+
+func (e *setupError) Unwrap() error { return e.err }
+
+// exitCode returns the process exit code for an error returned by run.
+func exitCode(err error) int {
+	var usageErr *usageError
+	var readErr *configReadError
+	var confErr *configError
+	var setupErr *setupError
+	var validationErr *validationError
+	switch {
+	case err == nil:
+		return exitSuccess
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &readErr):
+		return exitConfigRead
+	case errors.As(err, &confErr), errors.As(err, &validationErr):
+		return exitConfigInvalid
+	case errors.As(err, &setupErr):
+		return exitSetup
+	default:
+		return exitFailure
+	}
+}
@@ -1,25 +1,23 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"crypto/ed25519"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
-	"strings"
 	"sync"
 	"syscall"
+	"time"
 
-	"golang.org/x/text/encoding/unicode"
-
-	"github.com/gologme/log"
 	gsyslog "github.com/hashicorp/go-syslog"
 	"github.com/hjson/hjson-go"
 	"github.com/kardianos/minwinsvc"
@@ -33,12 +31,22 @@ import (
 
 	"github.com/yggdrasil-network/yggdrasil-go/src/core"
 	"github.com/yggdrasil-network/yggdrasil-go/src/multicast"
-	"github.com/yggdrasil-network/yggdrasil-go/src/tun"
 	"github.com/yggdrasil-network/yggdrasil-go/src/version"
 
+	"github.com/popura-network/Popura/src/accounting"
+	"github.com/popura-network/Popura/src/adminauth"
+	"github.com/popura-network/Popura/src/api"
 	"github.com/popura-network/Popura/src/autopeering"
+	"github.com/popura-network/Popura/src/events"
+	"github.com/popura-network/Popura/src/firewall"
+	"github.com/popura-network/Popura/src/health"
+	"github.com/popura-network/Popura/src/logging"
 	"github.com/popura-network/Popura/src/meshname"
+	"github.com/popura-network/Popura/src/metrics"
 	"github.com/popura-network/Popura/src/popura"
+	"github.com/popura-network/Popura/src/sdnotify"
+	"github.com/popura-network/Popura/src/shaping"
+	"github.com/popura-network/Popura/src/tun"
 )
 
 type node struct {
@@ -46,67 +54,105 @@ type node struct {
 	tun         *tun.TunAdapter
 	multicast   *multicast.Multicast
 	admin       *admin.AdminSocket
+	adminAuth   *adminauth.Proxy
+	shaper      *shaping.Shaper
+	firewall    *firewall.Firewall
 	meshname    popura.Module // meshname.MeshnameServer
 	autopeering popura.Module // autopeering.AutoPeering
+	metrics     popura.Module // metrics.MetricsServer
+	api         popura.Module // api.APIServer
+	health      *health.HealthServer
+	events      *events.Bus
+	hooks       popura.Module // events.HookRunner
+	monitor     popura.Module // events.Monitor
+	accounting  *accounting.Accounting
+	log         *logging.Logger
+	started     time.Time
+}
+
+func readConfig(log *logging.Logger, useconf bool, useconffile string, normaliseconf bool, popConfig *popura.PopuraConfig) (*config.NodeConfig, error) {
+	files, err := loadConfig(useconf, useconffile)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(files, popConfig)
 }
 
-func readConfig(log *log.Logger, useconf bool, useconffile string, normaliseconf bool) *config.NodeConfig {
+// loadConfig reads the configuration files in the order that they should be
+// merged.
+func loadConfig(useconf bool, useconffile string) ([]*configFile, error) {
 	// Use a configuration file. If -useconf, the configuration will be read
 	// from stdin. If -useconffile, the configuration will be read from the
 	// filesystem.
-	var conf []byte
-	var err error
 	if useconffile != "" {
-		// Read the file from the filesystem
-		conf, err = os.ReadFile(useconffile)
-	} else {
-		// Read the file from stdin.
-		conf, err = io.ReadAll(os.Stdin)
+		return readConfigPath(useconffile, nil)
 	}
+	// Read the file from stdin.
+	conf, err := io.ReadAll(os.Stdin)
 	if err != nil {
-		panic(err)
-	}
-	// If there's a byte order mark - which Windows 10 is now incredibly fond of
-	// throwing everywhere when it's converting things into UTF-16 for the hell
-	// of it - remove it and decode back down into UTF-8. This is necessary
-	// because hjson doesn't know what to do with UTF-16 and will panic
-	if bytes.Equal(conf[0:2], []byte{0xFF, 0xFE}) ||
-		bytes.Equal(conf[0:2], []byte{0xFE, 0xFF}) {
-		utf := unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
-		decoder := utf.NewDecoder()
-		conf, err = decoder.Bytes(conf)
-		if err != nil {
-			panic(err)
-		}
+		return nil, &configReadError{"stdin", err}
 	}
+	return parseConfigFile("stdin", conf, ".", nil)
+}
+
+// parseConfig merges the configuration files, including their Popura
+// sections, on top of the defaults.
+func parseConfig(files []*configFile, popConfig *popura.PopuraConfig) (*config.NodeConfig, error) {
+	source := files[0].source
 	// Generate a new configuration - this gives us a set of sane defaults -
 	// then parse the configuration we loaded above on top of it. The effect
 	// of this is that any configuration item that is missing from the provided
 	// configuration will use a sane default.
 	cfg := defaults.GenerateConfig()
-	var dat map[string]interface{}
-	if err := hjson.Unmarshal(conf, &dat); err != nil {
-		panic(err)
+	dat := make(map[string]interface{})
+	for _, f := range files {
+		mergeConfig(dat, f.dat)
 	}
 	// Sanitise the config
 	confJson, err := json.Marshal(dat)
 	if err != nil {
-		panic(err)
+		return nil, &configReadError{source, err}
 	}
 	if err := json.Unmarshal(confJson, &cfg); err != nil {
-		panic(err)
+		return nil, &configReadError{source, err}
 	}
 	// Overlay our newly mapped configuration onto the autoconf node config that
 	// we generated above.
 	if err = mapstructure.Decode(dat, &cfg); err != nil {
-		panic(err)
+		return nil, &configReadError{source, err}
+	}
+	// Overlay the Popura section, if there is one, onto the Popura defaults.
+	if section, ok := dat["Popura"]; ok {
+		if err = mapstructure.Decode(section, popConfig); err != nil {
+			return nil, &configError{"Popura", err}
+		}
+	}
+	// The private key may be kept in a separate file, so that the rest of the
+	// configuration doesn't have to be treated as a secret.
+	if path, ok := dat["PrivateKeyPath"]; ok {
+		if popConfig.PrivateKeyPath, ok = path.(string); !ok {
+			return nil, &configError{"PrivateKeyPath", errors.New("must be a string")}
+		}
+		if _, ok := dat["PrivateKey"]; ok && popConfig.PrivateKeyPath != "" {
+			return nil, &configError{"PrivateKeyPath", errors.New("can't be used together with PrivateKey")}
+		}
+		// A relative path is relative to the file that set it.
+		if popConfig.PrivateKeyPath != "" && !filepath.IsAbs(popConfig.PrivateKeyPath) {
+			var dir string
+			for _, f := range files {
+				if _, ok := f.dat["PrivateKeyPath"]; ok {
+					dir = f.dir
+				}
+			}
+			popConfig.PrivateKeyPath = filepath.Join(dir, popConfig.PrivateKeyPath)
+		}
 	}
-	return cfg
+	return cfg, nil
 }
 
 // Generates a new configuration and returns it in HJSON format. This is used
 // with -genconf.
-func doGenconf(isjson bool) string {
+func doGenconf(isjson bool) (string, error) {
 	cfg := defaults.GenerateConfig()
 	var bs []byte
 	var err error
@@ -116,33 +162,39 @@ func doGenconf(isjson bool) string {
 		bs, err = hjson.Marshal(cfg)
 	}
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return string(bs)
+	return string(bs), nil
 }
 
-func setLogLevel(loglevel string, logger *log.Logger) {
-	levels := [...]string{"error", "warn", "info", "debug", "trace"}
-	loglevel = strings.ToLower(loglevel)
-
-	contains := func() bool {
-		for _, l := range levels {
-			if l == loglevel {
-				return true
-			}
-		}
-		return false
+// decodePrivateKey decodes the hex encoded PrivateKey option.
+func decodePrivateKey(cfg *config.NodeConfig) (ed25519.PrivateKey, error) {
+	sk, err := hex.DecodeString(cfg.PrivateKey)
+	if err != nil {
+		return nil, &configError{"PrivateKey", err}
 	}
-
-	if !contains() { // set default log level
-		logger.Infoln("Loglevel parse failed. Set default level(info)")
-		loglevel = "info"
+	if len(sk) != ed25519.PrivateKeySize {
+		return nil, &configError{"PrivateKey", fmt.Errorf("expected %d bytes, got %d", ed25519.PrivateKeySize, len(sk))}
 	}
+	return ed25519.PrivateKey(sk), nil
+}
 
-	for _, l := range levels {
-		logger.EnableLevel(l)
-		if l == loglevel {
-			break
+// applyFlags overrides the configuration with the command line options that
+// have been given. Boolean options override it either way, e.g. -meshname=false
+// disables a resolver that is enabled in the configuration.
+func applyFlags(args yggArgs, popConfig *popura.PopuraConfig) {
+	if args.set["meshname"] {
+		popConfig.Meshname.Enable = args.meshnameenable
+	}
+	if args.meshnamelisten != "" {
+		popConfig.Meshname.Listen = args.meshnamelisten
+	}
+	if args.set["autopeer"] {
+		popConfig.Autopeering.Enable = args.autopeer
+	}
+	if _, levels, err := logging.ParseLevels(args.loglevel); err == nil {
+		for module, level := range levels {
+			popConfig.Logging.Levels[module] = level
 		}
 	}
 }
@@ -151,6 +203,8 @@ type yggArgs struct {
 	genconf        bool
 	useconf        bool
 	normaliseconf  bool
+	validateconf   bool
+	printeffconf   bool
 	confjson       bool
 	autoconf       bool
 	ver            bool
@@ -158,154 +212,290 @@ type yggArgs struct {
 	getsnet        bool
 	useconffile    string
 	logto          string
+	logmaxsize     int
+	logmaxage      time.Duration
+	logbackups     int
+	logformat      string
 	loglevel       string
 	autopeer       bool
 	meshnameenable bool
 	meshnamelisten string
+	set            map[string]bool // names of the options that were given
 }
 
+// getArgs parses the command line, and exits with exitUsage if it is invalid.
 func getArgs() yggArgs {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
 	genconf := flag.Bool("genconf", false, "print a new config to stdout")
 	useconf := flag.Bool("useconf", false, "read HJSON/JSON config from stdin")
 	useconffile := flag.String("useconffile", "", "read HJSON/JSON config from specified file path")
 	normaliseconf := flag.Bool("normaliseconf", false, "use in combination with either -useconf or -useconffile, outputs your configuration normalised")
-	confjson := flag.Bool("json", false, "print configuration from -genconf or -normaliseconf as JSON instead of HJSON")
+	validateconf := flag.Bool("validateconf", false, "use in combination with either -useconf or -useconffile, checks your configuration and reports every problem found")
+	printeffconf := flag.Bool("printeffectiveconf", false, "outputs the configuration in effect after applying environment variables and command line options")
+	confjson := flag.Bool("json", false, "print configuration from -genconf, -normaliseconf or -printeffectiveconf as JSON instead of HJSON")
 	autoconf := flag.Bool("autoconf", false, "automatic mode (dynamic IP, peer with IPv6 neighbors)")
 	ver := flag.Bool("version", false, "prints the version of this build")
 	logto := flag.String("logto", "stdout", "file path to log to, \"syslog\" or \"stdout\"")
+	logmaxsize := flag.Int("logmaxsize", 0, "rotate the log file once it is larger than this many megabytes, 0 to not rotate by size")
+	logmaxage := flag.Duration("logmaxage", 0, "rotate the log file once it has been written to for this long, e.g. 24h, 0 to not rotate by age")
+	logbackups := flag.Int("logbackups", 5, "number of rotated log files to keep")
 	getaddr := flag.Bool("address", false, "returns the IPv6 address as derived from the supplied configuration")
 	getsnet := flag.Bool("subnet", false, "returns the IPv6 subnet as derived from the supplied configuration")
-	loglevel := flag.String("loglevel", "info", "loglevel to enable")
+	loglevel := flag.String("loglevel", "info", "loglevel to enable, optionally followed by levels of single modules, e.g. \"info,core=warn,autopeering=debug\"")
+	logformat := flag.String("logformat", "text", "log format, \"text\" or \"json\" for one JSON object per line")
 	autopeer := flag.Bool("autopeer", false, "automatic Internet peering (using peers from github.com/yggdrasil-network/public-peers)")
 	meshnameenable := flag.Bool("meshname", false, "enable meshname resolver")
-	meshnamelisten := flag.String("meshnamelisten", "[::1]:53535", "meshname resolver listen address")
-	flag.Parse()
+	meshnamelisten := flag.String("meshnamelisten", "", "meshname resolver listen address (default from config, or [::1]:53535)")
+	switch err := flag.CommandLine.Parse(os.Args[1:]); {
+	case err == flag.ErrHelp:
+		os.Exit(exitSuccess)
+	case err != nil:
+		os.Exit(exitUsage)
+	case flag.NArg() > 0:
+		fmt.Fprintln(os.Stderr, "Unexpected argument:", flag.Arg(0))
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
 	return yggArgs{
 		genconf:        *genconf,
 		useconf:        *useconf,
 		useconffile:    *useconffile,
 		normaliseconf:  *normaliseconf,
+		validateconf:   *validateconf,
+		printeffconf:   *printeffconf,
 		confjson:       *confjson,
 		autoconf:       *autoconf,
 		ver:            *ver,
 		logto:          *logto,
+		logmaxsize:     *logmaxsize,
+		logmaxage:      *logmaxage,
+		logbackups:     *logbackups,
+		logformat:      *logformat,
 		getaddr:        *getaddr,
 		getsnet:        *getsnet,
 		loglevel:       *loglevel,
 		autopeer:       *autopeer,
 		meshnameenable: *meshnameenable,
 		meshnamelisten: *meshnamelisten,
+		set:            set,
 	}
 }
 
 // The main function is responsible for configuring and starting Yggdrasil.
-func run(args yggArgs, ctx context.Context) {
-	// Create a new logger that logs output to stdout.
-	var logger *log.Logger
+func run(args yggArgs, ctx context.Context) error {
+	// Create the loggers of the node, which log output to stdout by default.
+	var logout io.Writer
+	var logfile *logging.File
+	var err error
 	switch args.logto {
 	case "stdout":
-		logger = log.New(os.Stdout, "", log.Flags())
+		logout = os.Stdout
 	case "syslog":
 		if syslogger, err := gsyslog.NewLogger(gsyslog.LOG_NOTICE, "DAEMON", version.BuildName()); err == nil {
-			logger = log.New(syslogger, "", log.Flags())
+			logout = syslogger
 		}
 	default:
-		if logfd, err := os.OpenFile(args.logto, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
-			logger = log.New(logfd, "", log.Flags())
+		if logfile, err = logging.OpenFile(args.logto, int64(args.logmaxsize)<<20, args.logmaxage, args.logbackups); err == nil {
+			logout = logfile
 		}
 	}
-	if logger == nil {
-		logger = log.New(os.Stdout, "", log.Flags())
+	logformat := args.logformat
+	if logformat != "text" && logformat != "json" {
+		logformat = "text"
+	}
+	var logs *logging.Logging
+	if logout != nil {
+		logs = logging.New(logout, logformat)
+	} else {
+		logs = logging.New(os.Stdout, logformat)
+	}
+	logger := logs.Logger("main")
+	if logout == nil {
 		logger.Warnln("Logging defaulting to stdout")
 	}
+	if logformat != args.logformat {
+		logger.Warnln("Log format parse failed. Set default format(text)")
+	}
 
-	if args.normaliseconf {
-		setLogLevel("error", logger)
-	} else {
-		setLogLevel(args.loglevel, logger)
+	// Reopen the log file when asked to, so that it can be rotated by external
+	// tools such as logrotate.
+	if logfile != nil {
+		defer logfile.Close()
+		reopen := make(chan os.Signal, 1)
+		notifyReopen(reopen)
+		defer signal.Stop(reopen)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-reopen:
+					if err := logfile.Reopen(); err != nil {
+						logger.Errorln("Failed to reopen the log file:", err)
+					} else {
+						logger.Infoln("Reopened the log file")
+					}
+				}
+			}
+		}()
+	}
+
+	// Levels of single modules given with -loglevel are applied along with
+	// the configuration, see applyFlags.
+	loglevel, _, err := logging.ParseLevels(args.loglevel)
+	if err != nil {
+		logger.Warnln("Loglevel parse failed:", err)
+		logger.Infoln("Set default level(info)")
+		loglevel = "info"
+	}
+	if args.normaliseconf || args.validateconf || args.printeffconf {
+		loglevel = "error"
+	}
+	if loglevel != "" {
+		_ = logs.SetLevel("", loglevel)
 	}
 
 	var cfg *config.NodeConfig
 	popuraConfig := popura.GenerateConfig()
-	var err error
 	switch {
 	case args.ver:
 		fmt.Println("Build name:", version.BuildName())
 		fmt.Println("Build version:", version.BuildVersion())
-		return
+		return nil
 	case args.autoconf:
 		// Use an autoconf-generated config, this will give us random keys and
 		// port numbers, and will use an automatically selected TUN interface.
 		cfg = defaults.GenerateConfig()
+	case args.validateconf && (args.useconffile != "" || args.useconf):
+		return validate(args.useconf, args.useconffile, popuraConfig)
 	case args.useconffile != "" || args.useconf:
 		// Read the configuration from either stdin or from the filesystem
-		cfg = readConfig(logger, args.useconf, args.useconffile, args.normaliseconf)
+		if cfg, err = readConfig(logger, args.useconf, args.useconffile, args.normaliseconf, popuraConfig); err != nil {
+			return err
+		}
 		// If the -normaliseconf option was specified then remarshal the above
 		// configuration and print it back to stdout. This lets the user update
 		// their configuration file with newly mapped names (like above) or to
-		// convert from plain JSON to commented HJSON.
+		// convert from plain JSON to commented HJSON. When the configuration
+		// is split over several files, the merged result is printed.
 		if args.normaliseconf {
-			var bs []byte
-			if args.confjson {
-				bs, err = json.MarshalIndent(cfg, "", "  ")
-			} else {
-				bs, err = hjson.Marshal(cfg)
-			}
+			bs, err := marshalConfig(cfg, popuraConfig, args.confjson)
 			if err != nil {
-				panic(err)
+				return err
 			}
 			fmt.Println(string(bs))
-			return
+			return nil
 		}
 	case args.genconf:
 		// Generate a new configuration and print it to stdout.
-		fmt.Println(doGenconf(args.confjson))
-		return
+		conf, err := doGenconf(args.confjson)
+		if err != nil {
+			return err
+		}
+		fmt.Println(conf)
+		return nil
 	default:
-		// No flags were provided, therefore print the list of flags to stdout.
-		flag.PrintDefaults()
+		// No flags were provided, therefore print the list of flags.
+		flag.Usage()
+		return &usageError{"one of -useconffile, -useconf, -autoconf, -genconf or -version is required"}
 	}
-	// Have we got a working configuration? If we don't then it probably means
-	// that neither -autoconf, -useconf or -useconffile were set above. Stop
-	// if we don't.
-	if cfg == nil {
-		return
+	// Environment variables override the configuration, and command line
+	// options override both.
+	if err := applyEnv(os.Environ(), cfg, popuraConfig, logger); err != nil {
+		return err
 	}
-	// Have we been asked for the node address yet? If so, print it and then stop.
-	getNodeKey := func() ed25519.PublicKey {
-		if pubkey, err := hex.DecodeString(cfg.PrivateKey); err == nil {
-			return ed25519.PrivateKey(pubkey).Public().(ed25519.PublicKey)
+	applyFlags(args, popuraConfig)
+	// Take the private key from PrivateKeyPath or the environment, if given.
+	if source, err := popura.ResolvePrivateKey(cfg, popuraConfig.PrivateKeyPath, logger); err != nil {
+		return &configError{source, err}
+	}
+	if args.printeffconf {
+		// Don't print a key that was given in the environment.
+		if os.Getenv(popura.PrivateKeyEnv) != "" {
+			cfg.PrivateKey = ""
 		}
+		bs, err := marshalConfig(cfg, popuraConfig, args.confjson)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(bs))
 		return nil
 	}
+	// Have we been asked for the node address yet? If so, print it and then stop.
+	sk, err := decodePrivateKey(cfg)
+	if err != nil {
+		return err
+	}
 	switch {
 	case args.getaddr:
-		if key := getNodeKey(); key != nil {
-			addr := address.AddrForKey(key)
-			ip := net.IP(addr[:])
-			fmt.Println(ip.String())
-		}
-		return
+		addr := address.AddrForKey(sk.Public().(ed25519.PublicKey))
+		ip := net.IP(addr[:])
+		fmt.Println(ip.String())
+		return nil
 	case args.getsnet:
-		if key := getNodeKey(); key != nil {
-			snet := address.SubnetForKey(key)
-			ipnet := net.IPNet{
-				IP:   append(snet[:], 0, 0, 0, 0, 0, 0, 0, 0),
-				Mask: net.CIDRMask(len(snet)*8, 128),
-			}
-			fmt.Println(ipnet.String())
+		snet := address.SubnetForKey(sk.Public().(ed25519.PublicKey))
+		ipnet := net.IPNet{
+			IP:   append(snet[:], 0, 0, 0, 0, 0, 0, 0, 0),
+			Mask: net.CIDRMask(len(snet)*8, 128),
+		}
+		fmt.Println(ipnet.String())
+		return nil
+	}
+
+	// Set the levels of single modules, now that the configuration is known.
+	for module, level := range popuraConfig.Logging.Levels {
+		if err := logs.SetLevel(module, level); err != nil {
+			return &configError{"Popura.Logging.Levels", err}
 		}
-		return
 	}
 
 	n := &node{}
+	if err := n.setup(cfg, popuraConfig, args, sk, logs); err != nil {
+		n.stop()
+		return err
+	}
+
+	// Make some nice output that tells us what our IPv6 address and subnet are.
+	// This is just logged to stdout for the user.
+	address := n.core.Address()
+	subnet := n.core.Subnet()
+	public := n.core.GetSelf().Key
+	logger.Infof("Your public key is %s", hex.EncodeToString(public[:]))
+	logger.Infof("Your IPv6 address is %s", address.String())
+	logger.Infof("Your IPv6 subnet is %s", subnet.String())
+
+	// Tell the service manager, usually systemd, that the node is ready.
+	notifier, err := sdnotify.New()
+	if err != nil {
+		logger.Warnln("Failed to connect to the service manager:", err)
+	}
+	if notifier != nil {
+		defer notifier.Close()
+		go n.notify(ctx, notifier, logger)
+	}
+
+	// Block until we are told to shut down.
+	<-ctx.Done()
+
+	// Shut down the node.
+	if notifier != nil {
+		_ = notifier.Stopping()
+	}
+	n.stop()
+	return nil
+}
+
+// setup starts every subsystem of the node in order. If an error is returned,
+// the subsystems that were already started are left running for the caller
+// to stop.
+func (n *node) setup(cfg *config.NodeConfig, popuraConfig *popura.PopuraConfig, args yggArgs, sk ed25519.PrivateKey, logs *logging.Logging) error {
+	var err error
+	n.log = logs.Logger("main")
 
 	// Setup the Yggdrasil node itself.
 	{
-		sk, err := hex.DecodeString(cfg.PrivateKey)
-		if err != nil {
-			panic(err)
-		}
 		options := []core.SetupOption{
 			core.NodeInfo(cfg.NodeInfo),
 			core.NodeInfoPrivacy(cfg.NodeInfoPrivacy),
@@ -313,111 +503,339 @@ func run(args yggArgs, ctx context.Context) {
 		for _, addr := range cfg.Listen {
 			options = append(options, core.ListenAddress(addr))
 		}
-		for _, peer := range cfg.Peers {
-			options = append(options, core.Peer{URI: peer})
+		// The shaper adds the peers itself, through its relays.
+		if !popuraConfig.Shaping.Enable {
+			for _, peer := range cfg.Peers {
+				options = append(options, core.Peer{URI: peer})
+			}
 		}
 		for intf, peers := range cfg.InterfacePeers {
 			for _, peer := range peers {
 				options = append(options, core.Peer{URI: peer, SourceInterface: intf})
 			}
 		}
-		for _, allowed := range cfg.AllowedPublicKeys {
-			k, err := hex.DecodeString(allowed)
+		for i, allowed := range cfg.AllowedPublicKeys {
+			k, err := decodePublicKey(allowed)
 			if err != nil {
-				panic(err)
+				return &configError{fmt.Sprintf("AllowedPublicKeys[%d]", i), err}
 			}
-			options = append(options, core.AllowedPublicKey(k[:]))
+			options = append(options, core.AllowedPublicKey(k))
 		}
-		if n.core, err = core.New(sk[:], logger, options...); err != nil {
-			panic(err)
+		if n.core, err = core.New(sk, logs.Logger("core"), options...); err != nil {
+			return &setupError{"core", err}
 		}
+		n.started = time.Now()
 	}
 
-	// Setup the admin socket.
+	// Setup the shaper, which relays peers to and from the core. The modules
+	// that report on peers list them through it, to see their real addresses.
 	{
+		n.shaper = &shaping.Shaper{}
+		if err = n.shaper.Init(n.core, cfg, popuraConfig, logs.Logger("shaping"), nil); err != nil {
+			return &configError{"Popura.Shaping", err}
+		}
+		if err = n.shaper.Start(); err != nil {
+			return &setupError{"shaping", err}
+		}
+		if popuraConfig.Shaping.Enable {
+			for i, peer := range cfg.Peers {
+				uri, err := n.shaper.RelayPeer(peer)
+				if err == nil {
+					err = n.core.AddPeer(uri, "")
+				}
+				if err != nil {
+					return &configError{fmt.Sprintf("Peers[%d]", i), err}
+				}
+			}
+		}
+	}
+
+	// Events are published from here on.
+	n.events = events.NewBus()
+
+	// Setup the admin socket. When tokens are configured, it listens on a
+	// private socket behind a proxy on AdminListen, which checks them.
+	{
+		n.adminAuth = &adminauth.Proxy{}
+		if err = n.adminAuth.Init(n.core, cfg, popuraConfig, logs.Logger("admin"), nil); err != nil {
+			return &configError{"Popura.AdminAuth", err}
+		}
+		// Starting the proxy creates the directory for the admin socket.
+		// Until the admin socket is up, clients are told it isn't available.
+		if err = n.adminAuth.Start(); err != nil {
+			return &setupError{"admin socket", err}
+		}
+		listen := cfg.AdminListen
+		if n.adminAuth.Enabled() {
+			listen = n.adminAuth.Backend()
+		}
 		options := []admin.SetupOption{
-			admin.ListenAddress(cfg.AdminListen),
+			admin.ListenAddress(listen),
 		}
-		if n.admin, err = admin.New(n.core, logger, options...); err != nil {
-			panic(err)
+		if n.admin, err = admin.New(n.core, logs.Logger("admin"), options...); err != nil {
+			return &setupError{"admin socket", err}
 		}
 		if n.admin != nil {
 			n.admin.SetupAdminHandlers()
+			n.shaper.SetupAdminHandlers(n.admin)
+			logs.SetupAdminHandlers(n.admin)
 		}
 	}
 
 	// Setup the multicast module.
 	{
 		options := []multicast.SetupOption{}
-		for _, intf := range cfg.MulticastInterfaces {
+		for i, intf := range cfg.MulticastInterfaces {
+			regex, err := regexp.Compile(intf.Regex)
+			if err != nil {
+				return &configError{fmt.Sprintf("MulticastInterfaces[%d].Regex", i), err}
+			}
 			options = append(options, multicast.MulticastInterface{
-				Regex:    regexp.MustCompile(intf.Regex),
+				Regex:    regex,
 				Beacon:   intf.Beacon,
 				Listen:   intf.Listen,
 				Port:     intf.Port,
 				Priority: intf.Priority,
 			})
 		}
-		if n.multicast, err = multicast.New(n.core, logger, options...); err != nil {
-			panic(err)
+		if n.multicast, err = multicast.New(n.core, logs.Logger("multicast").Logger, options...); err != nil {
+			return &setupError{"multicast", err}
 		}
 		if n.admin != nil && n.multicast != nil {
 			n.multicast.SetupAdminHandlers(n.admin)
 		}
 	}
 
+	// Setup the firewall, which filters the packets passed to the TUN module.
+	{
+		n.firewall = &firewall.Firewall{}
+		if err = n.firewall.Init(n.core, cfg, popuraConfig, logs.Logger("firewall"), nil); err != nil {
+			return &configError{"Popura.Firewall", err}
+		}
+		if n.admin != nil {
+			n.firewall.SetupAdminHandlers(n.admin)
+		}
+		if err = n.firewall.Start(); err != nil {
+			return &setupError{"firewall", err}
+		}
+	}
+
+	// Setup traffic accounting, which counts the packets passed to the TUN
+	// module.
+	{
+		n.accounting = &accounting.Accounting{}
+		if err = n.accounting.Init(n.core, cfg, popuraConfig, logs.Logger("accounting"), n.shaper); err != nil {
+			return &configError{"Popura.Accounting", err}
+		}
+		if n.admin != nil {
+			n.accounting.SetupAdminHandlers(n.admin)
+		}
+		if err = n.accounting.Start(); err != nil {
+			return &setupError{"accounting", err}
+		}
+	}
+
 	// Setup the TUN module.
 	{
 		options := []tun.SetupOption{
 			tun.InterfaceName(cfg.IfName),
 			tun.InterfaceMTU(cfg.IfMTU),
 		}
-		if n.tun, err = tun.New(ipv6rwc.NewReadWriteCloser(n.core), logger, options...); err != nil {
-			panic(err)
+		rwc := n.firewall.ReadWriteCloser(n.accounting.ReadWriteCloser(ipv6rwc.NewReadWriteCloser(n.core)))
+		if n.tun, err = tun.New(rwc, logs.Logger("tun"), options...); err != nil {
+			return &setupError{"TUN adapter", err}
 		}
 		if n.admin != nil && n.tun != nil {
 			n.tun.SetupAdminHandlers(n.admin)
 		}
 	}
 
+	// Setup the event hooks before the modules that publish events.
+	{
+		n.hooks = &events.HookRunner{}
+		if err = n.hooks.Init(n.core, cfg, popuraConfig, logs.Logger("hooks"), n.events); err != nil {
+			return &configError{"Popura.Hooks", err}
+		}
+		if err = n.hooks.Start(); err != nil {
+			return &setupError{"hooks", err}
+		}
+	}
+
 	// Setup Popura modules
 	{
 		n.meshname = &meshname.MeshnameServer{}
 		n.autopeering = &autopeering.AutoPeering{}
 
-		popuraConfig.Meshname.Enable = args.meshnameenable
-		popuraConfig.Meshname.Listen = args.meshnamelisten
-		_ = n.meshname.Init(n.core, cfg, popuraConfig, logger, nil)
+		if err = n.meshname.Init(n.core, cfg, popuraConfig, logs.Logger("meshname"), n.events); err != nil {
+			return &configError{"Popura.Meshname", err}
+		}
+		if n.admin != nil {
+			n.meshname.SetupAdminHandlers(n.admin)
+		}
 		if err = n.meshname.Start(); err != nil {
-			panic(err)
+			return &setupError{"meshname", err}
 		}
 
-		popuraConfig.Autopeering.Enable = args.autopeer
-		_ = n.autopeering.Init(n.core, cfg, popuraConfig, logger, nil)
+		if err = n.autopeering.Init(n.core, cfg, popuraConfig, logs.Logger("autopeering"), autopeering.Options{Events: n.events, Peers: n.shaper, Relay: n.shaper}); err != nil {
+			return &configError{"Popura.Autopeering", err}
+		}
+		if n.admin != nil {
+			n.autopeering.SetupAdminHandlers(n.admin)
+		}
 		if err = n.autopeering.Start(); err != nil {
-			panic(err)
+			return &setupError{"autopeering", err}
+		}
+
+		n.monitor = &events.Monitor{}
+		if err = n.monitor.Init(n.core, cfg, popuraConfig, logs.Logger("events"), events.MonitorOptions{Bus: n.events, Peers: n.shaper}); err != nil {
+			return &configError{"Popura", err}
+		}
+		if n.admin != nil {
+			n.monitor.SetupAdminHandlers(n.admin)
+		}
+		if err = n.monitor.Start(); err != nil {
+			return &setupError{"event monitor", err}
 		}
 	}
 
-	// Make some nice output that tells us what our IPv6 address and subnet are.
-	// This is just logged to stdout for the user.
-	address := n.core.Address()
-	subnet := n.core.Subnet()
-	public := n.core.GetSelf().Key
-	logger.Infof("Your public key is %s", hex.EncodeToString(public[:]))
-	logger.Infof("Your IPv6 address is %s", address.String())
-	logger.Infof("Your IPv6 subnet is %s", subnet.String())
+	// Setup the metrics server, which reports on everything set up above.
+	{
+		n.metrics = &metrics.MetricsServer{}
+		options := metrics.Options{
+			TUN:   n.tun,
+			Peers: n.shaper,
+			Sources: []metrics.Source{
+				n.meshname.(metrics.Source),
+				n.autopeering.(metrics.Source),
+				n.accounting,
+			},
+			Started: n.started,
+		}
+		if err = n.metrics.Init(n.core, cfg, popuraConfig, logs.Logger("metrics"), options); err != nil {
+			return &configError{"Popura.Metrics", err}
+		}
+		if err = n.metrics.Start(); err != nil {
+			return &setupError{"metrics", err}
+		}
+	}
 
-	// Block until we are told to shut down.
-	<-ctx.Done()
+	// Setup the API server, which forwards requests to the admin socket.
+	{
+		n.api = &api.APIServer{}
+		if err = n.api.Init(n.core, cfg, popuraConfig, logs.Logger("api"), nil); err != nil {
+			return &configError{"Popura.API", err}
+		}
+		if err = n.api.Start(); err != nil {
+			return &setupError{"API server", err}
+		}
+	}
 
-	// Shut down the node.
-	_ = n.admin.Stop()
-	_ = n.multicast.Stop()
-	_ = n.tun.Stop()
-	_ = n.autopeering.Stop()
-	_ = n.meshname.Stop()
-	n.core.Stop()
+	// Setup the health check server, which checks everything set up above
+	// for the health probes and the service manager.
+	{
+		n.health = &health.HealthServer{}
+		options := health.Options{
+			Admin: n.admin,
+			TUN:   n.tun,
+			Modules: map[string]popura.Module{
+				"hooks":       n.hooks,
+				"meshname":    n.meshname,
+				"autopeering": n.autopeering,
+				"events":      n.monitor,
+				"accounting":  n.accounting,
+				"metrics":     n.metrics,
+				"api":         n.api,
+				"adminauth":   n.adminAuth,
+				"shaping":     n.shaper,
+				"firewall":    n.firewall,
+				"health":      n.health,
+			},
+		}
+		if err = n.health.Init(n.core, cfg, popuraConfig, logs.Logger("health"), options); err != nil {
+			return &configError{"Popura.Health", err}
+		}
+		if err = n.health.Start(); err != nil {
+			return &setupError{"health", err}
+		}
+	}
+
+	return nil
+}
+
+// stopTimeout bounds how long each part of the node may take to stop.
+const stopTimeout = 5 * time.Second
+
+// stop shuts down the parts of the node in the reverse order of setup, so
+// that nothing is stopped while a part set up after it may still use it.
+func (n *node) stop() {
+	if n.health != nil {
+		n.stopPart("health check server", n.health.Stop)
+	}
+	if n.api != nil {
+		n.stopPart("API server", n.api.Stop)
+	}
+	if n.metrics != nil {
+		n.stopPart("metrics server", n.metrics.Stop)
+	}
+	if n.monitor != nil {
+		n.stopPart("event monitor", n.monitor.Stop)
+	}
+	if n.autopeering != nil {
+		n.stopPart("autopeering", n.autopeering.Stop)
+	}
+	if n.meshname != nil {
+		n.stopPart("meshname", n.meshname.Stop)
+	}
+	if n.hooks != nil {
+		n.stopPart("hooks", n.hooks.Stop)
+	}
+	if n.tun != nil {
+		n.stopPart("TUN adapter", n.tun.Stop)
+	}
+	if n.accounting != nil {
+		n.stopPart("accounting", n.accounting.Stop)
+	}
+	if n.firewall != nil {
+		n.stopPart("firewall", n.firewall.Stop)
+	}
+	if n.multicast != nil {
+		n.stopPart("multicast", n.multicast.Stop)
+	}
+	if n.admin != nil {
+		n.stopPart("admin socket", n.admin.Stop)
+	}
+	if n.adminAuth != nil {
+		n.stopPart("admin socket proxy", n.adminAuth.Stop)
+	}
+	if n.shaper != nil {
+		n.stopPart("shaper", n.shaper.Stop)
+	}
+	if n.core != nil {
+		n.stopPart("core", func() error {
+			n.core.Stop()
+			return nil
+		})
+	}
+}
+
+// stopPart calls stop and logs its error. If stop takes longer than
+// stopTimeout, it is left to finish in the background so that the rest of the
+// node can still be shut down.
+func (n *node) stopPart(name string, stop func() error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- stop()
+	}()
+	timer := time.NewTimer(stopTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			n.log.Errorf("Failed to stop the %s: %v", name, err)
+		}
+	case <-timer.C:
+		n.log.Errorf("Gave up stopping the %s after %s", name, stopTimeout)
+	}
 }
 
 func main() {
@@ -426,15 +844,32 @@ func main() {
 	// Catch interrupts from the operating system to exit gracefully.
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
+	// Exit straight away on a second interrupt, without waiting for the node
+	// to shut down.
+	go func() {
+		<-ctx.Done()
+		force := make(chan os.Signal, 1)
+		signal.Notify(force, os.Interrupt, syscall.SIGTERM)
+		<-force
+		fmt.Fprintln(os.Stderr, "Interrupted again, exiting without shutting down")
+		os.Exit(1)
+	}()
+
 	// Capture the service being stopped on Windows.
 	minwinsvc.SetOnExit(cancel)
 
 	// Start the node, block and then wait for it to shut down.
 	var wg sync.WaitGroup
+	var err error
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		run(args, ctx)
+		err = run(args, ctx)
 	}()
 	wg.Wait()
+	cancel()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
+	}
 }
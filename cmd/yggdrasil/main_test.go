@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/defaults"
+
+	"github.com/popura-network/Popura/src/autopeering"
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+func TestStartStop(t *testing.T) {
+	cfg := defaults.GenerateConfig()
+	cfg.Listen = []string{"tcp://127.0.0.1:0"}
+	adminAddr := freeAddress(t)
+	cfg.AdminListen = "tcp://" + adminAddr
+	// Multicast and autopeering are started as well, to check that they
+	// stop, but the TUN adapter needs privileges that tests don't have.
+	cfg.IfName = "none"
+	popuraConfig := popura.GenerateConfig()
+	popuraConfig.Meshname.Enable = true
+	popuraConfig.Meshname.Listen = "127.0.0.1:0"
+	popuraConfig.Metrics.Enable = true
+	popuraConfig.Metrics.Listen = "127.0.0.1:0"
+	popuraConfig.Health.Enable = true
+	popuraConfig.Health.Listen = "127.0.0.1:0"
+	popuraConfig.Autopeering.Enable = true
+	// Autopeering only finds a peer that isn't listening, rather than
+	// connecting to the public peers.
+	autopeering.PublicPeers = "tcp://" + freeAddress(t)
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := logging.New(io.Discard, "text")
+
+	// Start and stop once before counting, so that goroutines started once
+	// per process, such as those of the resolver, are not counted as leaks.
+	before := 0
+	for i := 0; i < 4; i++ {
+		n := &node{}
+		if err := n.setup(cfg, popuraConfig, yggArgs{}, sk, logs); err != nil {
+			n.stop()
+			t.Fatalf("Failed to start the node: %s", err)
+		}
+		if err := n.health.Liveness().Err(); err != nil {
+			t.Errorf("Health check of the started node failed: %s", err)
+		}
+		// The admin socket starts listening in the background, and can't be
+		// stopped before it does.
+		waitListening(t, adminAddr)
+		n.stop()
+		if i == 0 {
+			before = settledGoroutines(-1)
+		}
+	}
+
+	if after := settledGoroutines(before); after > before {
+		buf := make([]byte, 1<<20)
+		t.Errorf("%d goroutines left running after stopping the node, %d before:\n%s",
+			after, before, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// settledGoroutines waits for goroutines that are still finishing to exit,
+// until there are at most want of them, or if want is negative until their
+// number stops changing. It returns how many are left.
+func settledGoroutines(want int) int {
+	count := runtime.NumGoroutine()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+		last := count
+		count = runtime.NumGoroutine()
+		if (want < 0 && count == last) || (want >= 0 && count <= want) {
+			break
+		}
+	}
+	return count
+}
+
+// freeAddress returns a local TCP address that isn't in use.
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// waitListening waits until a connection to addr succeeds.
+func waitListening(t *testing.T, addr string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Nothing is listening on %s", addr)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/sdnotify"
+)
+
+// statusInterval is how often the status of the node is sent to the service
+// manager, unless watchdog pings are due more often.
+const statusInterval = 30 * time.Second
+
+// status returns a line describing the state of the node.
+func (n *node) status() string {
+	return fmt.Sprintf("%d peers, %d sessions", len(n.core.GetPeers()), len(n.core.GetSessions()))
+}
+
+// notify tells the service manager that the node is ready, then keeps sending
+// it the status of the node until ctx is done. If the service manager asks for
+// watchdog pings, they are only sent while the node passes the liveness check
+// of the health server, so that a node that stops working is restarted.
+func (n *node) notify(ctx context.Context, notifier *sdnotify.Notifier, logger *logging.Logger) {
+	watchdog, err := sdnotify.WatchdogInterval()
+	if err != nil {
+		logger.Warnln("Ignoring the watchdog of the service manager:", err)
+	}
+	interval := statusInterval
+	if watchdog > 0 && watchdog/2 < interval {
+		interval = watchdog / 2
+	}
+	if err := notifier.Ready(n.status()); err != nil {
+		logger.Warnln("Failed to notify the service manager:", err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := n.health.Liveness().Err(); err != nil {
+			logger.Errorln("Health check failed:", err)
+			_ = notifier.Status("Unhealthy: " + err.Error())
+			continue
+		}
+		_ = notifier.Status(n.status())
+		if watchdog > 0 {
+			_ = notifier.Watchdog()
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReopen relays SIGUSR1, which asks for the log file to be reopened.
+func notifyReopen(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// notifyReopen does nothing, as there is no signal to reopen the log file on
+// Windows.
+func notifyReopen(c chan<- os.Signal) {}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gologme/log"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+
+	"github.com/popura-network/Popura/src/adminauth"
+	"github.com/popura-network/Popura/src/events"
+	"github.com/popura-network/Popura/src/firewall"
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/meshname"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+// configProblem is a single problem found in the configuration by
+// -validateconf. The path is used to locate the file and line that set the
+// option.
+type configProblem struct {
+	key    string
+	path   []string
+	source string
+	line   int
+	err    error
+}
+
+// validationError is returned by -validateconf when problems were found.
+type validationError struct {
+	problems []configProblem
+}
+
+func (e *validationError) Error() string {
+	if len(e.problems) == 1 {
+		return "1 problem found in config"
+	}
+	return fmt.Sprintf("%d problems found in config", len(e.problems))
+}
+
+// validate implements -validateconf. Every problem found is printed to stderr
+// as "source:line: key: error", so that editors can jump to it.
+func validate(useconf bool, useconffile string, popConfig *popura.PopuraConfig) error {
+	files, err := loadConfig(useconf, useconffile)
+	if err != nil {
+		return err
+	}
+	cfg, err := parseConfig(files, popConfig)
+	if err != nil {
+		return err
+	}
+	problems := validateConfig(files, cfg, popConfig)
+	if len(problems) == 0 {
+		fmt.Println("Configuration is valid")
+		return nil
+	}
+	for _, p := range problems {
+		if p.line > 0 {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s: %v\n", p.source, p.line, p.key, p.err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s: %v\n", p.source, p.key, p.err)
+		}
+	}
+	return &validationError{problems}
+}
+
+// validateConfig checks every option of cfg and popConfig, rather than
+// stopping at the first error like node setup does, and locates each problem
+// in the configuration files.
+func validateConfig(files []*configFile, cfg *config.NodeConfig, popConfig *popura.PopuraConfig) []configProblem {
+	var problems []configProblem
+	add := func(key string, err error, path ...string) {
+		problems = append(problems, configProblem{key: key, path: path, err: err})
+	}
+
+	// Warnings don't make the configuration invalid, so they are only printed.
+	warnings := log.New(os.Stderr, "warning: ", 0)
+	warnings.EnableLevel("warn")
+	var sk ed25519.PrivateKey
+	if source, err := popura.ResolvePrivateKey(cfg, popConfig.PrivateKeyPath, warnings); err != nil {
+		add(source, err, source)
+	} else if sk, err = decodePrivateKey(cfg); err != nil {
+		add("PrivateKey", errors.Unwrap(err), "PrivateKey")
+	} else if cfg.PublicKey != "" && cfg.PublicKey != hex.EncodeToString(sk.Public().(ed25519.PublicKey)) {
+		add("PublicKey", errors.New("does not match PrivateKey"), "PublicKey")
+	}
+	for i, peer := range cfg.Peers {
+		if err := checkPeerURI(peer); err != nil {
+			add(fmt.Sprintf("Peers[%d]", i), err, "Peers", peer)
+		}
+	}
+	for intf, peers := range cfg.InterfacePeers {
+		for i, peer := range peers {
+			if err := checkPeerURI(peer); err != nil {
+				add(fmt.Sprintf("InterfacePeers[%s][%d]", intf, i), err, "InterfacePeers", intf, peer)
+			}
+		}
+	}
+	for i, listen := range cfg.Listen {
+		if err := checkListenURI(listen); err != nil {
+			add(fmt.Sprintf("Listen[%d]", i), err, "Listen", listen)
+		}
+	}
+	if err := checkAdminListen(cfg.AdminListen); err != nil {
+		add("AdminListen", err, "AdminListen")
+	}
+	for i, intf := range cfg.MulticastInterfaces {
+		if _, err := regexp.Compile(intf.Regex); err != nil {
+			add(fmt.Sprintf("MulticastInterfaces[%d].Regex", i), err, "MulticastInterfaces", intf.Regex)
+		}
+	}
+	for i, allowed := range cfg.AllowedPublicKeys {
+		if _, err := decodePublicKey(allowed); err != nil {
+			add(fmt.Sprintf("AllowedPublicKeys[%d]", i), err, "AllowedPublicKeys", allowed)
+		}
+	}
+	if cfg.IfMTU < 1280 || cfg.IfMTU > 65535 {
+		add("IfMTU", fmt.Errorf("%d is outside of the range 1280-65535", cfg.IfMTU), "IfMTU")
+	}
+
+	mc := popConfig.Meshname
+	if err := checkHostPort(mc.Listen); err != nil {
+		add("Popura.Meshname.Listen", err, "Popura", "Meshname", "Listen")
+	}
+	if mc.TLSListen != "" {
+		if err := checkHostPort(mc.TLSListen); err != nil {
+			add("Popura.Meshname.TLSListen", err, "Popura", "Meshname", "TLSListen")
+		}
+	}
+	if mc.HTTPSListen != "" {
+		if err := checkHostPort(mc.HTTPSListen); err != nil {
+			add("Popura.Meshname.HTTPSListen", err, "Popura", "Meshname", "HTTPSListen")
+		}
+	}
+	if mc.TLSCertFile != "" && mc.TLSKeyFile == "" {
+		add("Popura.Meshname.TLSCertFile", errors.New("TLSKeyFile must also be set"), "Popura", "Meshname", "TLSCertFile")
+	} else if mc.TLSCertFile == "" && mc.TLSKeyFile != "" {
+		add("Popura.Meshname.TLSKeyFile", errors.New("TLSCertFile must also be set"), "Popura", "Meshname", "TLSKeyFile")
+	}
+	if mc.RateLimit < 0 {
+		add("Popura.Meshname.RateLimit", errors.New("must not be negative"), "Popura", "Meshname", "RateLimit")
+	}
+	if popConfig.Metrics.Enable {
+		if err := checkHostPort(popConfig.Metrics.Listen); err != nil {
+			add("Popura.Metrics.Listen", err, "Popura", "Metrics", "Listen")
+		}
+	}
+	if popConfig.API.Enable {
+		if err := checkHostPort(popConfig.API.Listen); err != nil {
+			add("Popura.API.Listen", err, "Popura", "API", "Listen")
+		}
+		if cfg.AdminListen == "" || cfg.AdminListen == "none" {
+			add("Popura.API.Enable", errors.New("requires AdminListen"), "Popura", "API", "Enable")
+		}
+	}
+	if popConfig.Health.Enable {
+		if err := checkHostPort(popConfig.Health.Listen); err != nil {
+			add("Popura.Health.Listen", err, "Popura", "Health", "Listen")
+		}
+	}
+	if popConfig.Health.MinPeers < 0 {
+		add("Popura.Health.MinPeers", errors.New("must not be negative"), "Popura", "Health", "MinPeers")
+	}
+	hookTypes := make([]string, 0, len(popConfig.Hooks.Commands))
+	for typ := range popConfig.Hooks.Commands {
+		hookTypes = append(hookTypes, typ)
+	}
+	sort.Strings(hookTypes)
+	for _, typ := range hookTypes {
+		key := "Popura.Hooks.Commands." + typ
+		if typ != "*" && !events.IsType(typ) {
+			add(key, errors.New("unknown event type"), "Popura", "Hooks", "Commands", typ)
+			continue
+		}
+		for i, command := range popConfig.Hooks.Commands[typ] {
+			args := strings.Fields(command)
+			if len(args) == 0 {
+				add(fmt.Sprintf("%s[%d]", key, i), errors.New("empty command"), "Popura", "Hooks", "Commands", typ)
+			} else if _, err := exec.LookPath(args[0]); err != nil {
+				add(fmt.Sprintf("%s[%d]", key, i), err, "Popura", "Hooks", "Commands", typ)
+			}
+		}
+	}
+	if popConfig.Hooks.MaxRunning < 1 {
+		add("Popura.Hooks.MaxRunning", errors.New("must be at least 1"), "Popura", "Hooks", "MaxRunning")
+	}
+	if popConfig.Hooks.Timeout < 1 {
+		add("Popura.Hooks.Timeout", errors.New("must be at least 1"), "Popura", "Hooks", "Timeout")
+	}
+	if popConfig.Accounting.Enable {
+		if popConfig.Accounting.StateDir == "" {
+			add("Popura.Accounting.StateDir", errors.New("must be set"), "Popura", "Accounting", "StateDir")
+		}
+		if popConfig.Accounting.Days < 1 {
+			add("Popura.Accounting.Days", errors.New("must be at least 1"), "Popura", "Accounting", "Days")
+		}
+	}
+	if popConfig.Shaping.Enable {
+		listening := make(map[string]bool)
+		for _, listen := range cfg.Listen {
+			if u, err := url.Parse(listen); err == nil {
+				listening[u.Host] = true
+			}
+		}
+		for i, listen := range popConfig.Shaping.Listen {
+			key := fmt.Sprintf("Popura.Shaping.Listen[%d]", i)
+			u, err := url.Parse(listen)
+			if err == nil && u.Scheme != "tcp" && u.Scheme != "tls" {
+				err = errors.New("must be a tcp:// or tls:// address")
+			}
+			if err == nil {
+				err = checkHostPort(u.Host)
+			}
+			if err == nil && listening[u.Host] {
+				err = errors.New("is also in Listen")
+			}
+			if err != nil {
+				add(key, err, "Popura", "Shaping", "Listen")
+			}
+		}
+	}
+	shapedKeys := make([]string, 0, len(popConfig.Shaping.Keys))
+	for key := range popConfig.Shaping.Keys {
+		shapedKeys = append(shapedKeys, key)
+	}
+	sort.Strings(shapedKeys)
+	for _, key := range shapedKeys {
+		if b, err := hex.DecodeString(key); err != nil || len(b) != ed25519.PublicKeySize {
+			add("Popura.Shaping.Keys."+key, errors.New("not a public key in hex"), "Popura", "Shaping", "Keys", key)
+		}
+	}
+	if popConfig.Firewall.Enable {
+		if d := strings.ToLower(popConfig.Firewall.Default); d != firewall.ActionAccept && d != firewall.ActionDrop {
+			add("Popura.Firewall.Default", fmt.Errorf("must be %q or %q", firewall.ActionAccept, firewall.ActionDrop), "Popura", "Firewall", "Default")
+		}
+	}
+	for i, rule := range popConfig.Firewall.Rules {
+		if err := firewall.CheckRule(rule); err != nil {
+			add(fmt.Sprintf("Popura.Firewall.Rules[%d]", i), err, "Popura", "Firewall", "Rules")
+		}
+	}
+	seenTokens := make(map[string]bool)
+	for i, t := range popConfig.AdminAuth.Tokens {
+		key := fmt.Sprintf("Popura.AdminAuth.Tokens[%d]", i)
+		switch {
+		case t.Token == "":
+			add(key+".Token", errors.New("must not be empty"), "Popura", "AdminAuth", "Tokens")
+		case seenTokens[t.Token]:
+			add(key+".Token", errors.New("is given more than once"), "Popura", "AdminAuth", "Tokens")
+		}
+		seenTokens[t.Token] = true
+		if t.Role != adminauth.RoleAdmin && t.Role != adminauth.RoleRead {
+			add(key+".Role", fmt.Errorf("must be %q or %q", adminauth.RoleAdmin, adminauth.RoleRead), "Popura", "AdminAuth", "Tokens")
+		}
+	}
+	modules := make([]string, 0, len(popConfig.Logging.Levels))
+	for module := range popConfig.Logging.Levels {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	logs := logging.New(io.Discard, "text")
+	for _, module := range modules {
+		if err := logs.SetLevel(module, popConfig.Logging.Levels[module]); err != nil {
+			add("Popura.Logging.Levels."+module, err, "Popura", "Logging", "Levels", module)
+		}
+	}
+	if sk != nil {
+		addr := address.AddrForKey(sk.Public().(ed25519.PublicKey))
+		for i, record := range mc.Records {
+			if err := meshname.CheckRecord(net.IP(addr[:]), record); err != nil {
+				add(fmt.Sprintf("Popura.Meshname.Records[%d]", i), err, "Popura", "Meshname", "Records", record)
+			}
+		}
+	}
+
+	// Options set in more than one file take their value from the last one.
+	for i := range problems {
+		problems[i].source = files[0].source
+		for j := len(files) - 1; j >= 0; j-- {
+			if line := findLine(files[j].data, problems[i].path); line > 0 {
+				problems[i].source, problems[i].line = files[j].source, line
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// decodePublicKey decodes a hex encoded public key, e.g. from the
+// AllowedPublicKeys option.
+func decodePublicKey(s string) (ed25519.PublicKey, error) {
+	k, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(k) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("expected %d bytes, got %d", ed25519.PublicKeySize, len(k))
+	}
+	return ed25519.PublicKey(k), nil
+}
+
+func checkPeerURI(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "tcp", "tls", "socks":
+		return checkHostPort(u.Host)
+	case "unix":
+		if u.Path == "" {
+			return errors.New("missing socket path")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported scheme %q, expected tcp, tls, socks or unix", u.Scheme)
+	}
+}
+
+func checkListenURI(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "tcp", "tls":
+		return checkHostPort(u.Host)
+	case "unix":
+		if u.Path == "" {
+			return errors.New("missing socket path")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported scheme %q, expected tcp, tls or unix", u.Scheme)
+	}
+}
+
+func checkAdminListen(s string) error {
+	if s == "none" || s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "unix":
+		if len(s) <= len("unix://") {
+			return errors.New("missing socket path")
+		}
+		return nil
+	case "tcp":
+		return checkHostPort(u.Host)
+	default:
+		return checkHostPort(s)
+	}
+}
+
+func checkHostPort(s string) error {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(host, " /") {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// findLine returns the 1-based line number in conf where the option at path
+// is set. Each element of path is searched for after the line of the one
+// before it, as either a key or a string value. Zero is returned if the path
+// couldn't be found, e.g. because the option was left at its default.
+func findLine(conf []byte, path []string) int {
+	if len(path) == 0 {
+		return 0
+	}
+	lines := strings.Split(string(conf), "\n")
+	line := 0
+	for i, elem := range path {
+		key := regexp.MustCompile(`(^|[\s{,])"?` + regexp.QuoteMeta(elem) + `"?\s*:`)
+		found := false
+		for ; line < len(lines); line++ {
+			if key.MatchString(lines[line]) || (i > 0 && strings.Contains(lines[line], elem)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0
+		}
+	}
+	return line + 1
+}
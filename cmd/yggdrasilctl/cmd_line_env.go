@@ -16,6 +16,7 @@ import (
 type CmdLineEnv struct {
 	args             []string
 	endpoint, server string
+	token            string
 	injson, ver      bool
 }
 
@@ -40,11 +41,16 @@ func (cmdLineEnv *CmdLineEnv) parseFlagsAndArgs() {
 		fmt.Println("  - ", os.Args[0], "getPeers")
 		fmt.Println("  - ", os.Args[0], "-v getSelf")
 		fmt.Println("  - ", os.Args[0], "setTunTap name=auto mtu=1500 tap_mode=false")
+		fmt.Println("  - ", os.Args[0], "meshnameEncode ip=200:6fc8:9220:f400:5cc2:305a:4ac6:967e")
+		fmt.Println("  - ", os.Args[0], "subscribe events=peers,sessions")
+		fmt.Println("  - ", os.Args[0], "watch")
 		fmt.Println("  - ", os.Args[0], "-endpoint=tcp://localhost:9001 getDHT")
 		fmt.Println("  - ", os.Args[0], "-endpoint=unix:///var/run/ygg.sock getDHT")
+		fmt.Println("  - ", os.Args[0], "-token=secret addPeer uri=tls://example.com:443")
 	}
 
 	server := flag.String("endpoint", cmdLineEnv.endpoint, "Admin socket endpoint")
+	token := flag.String("token", os.Getenv("YGGDRASILCTL_TOKEN"), "Token for the admin socket, if it requires one (default from $YGGDRASILCTL_TOKEN)")
 	injson := flag.Bool("json", false, "Output in JSON format (as opposed to pretty-print)")
 	ver := flag.Bool("version", false, "Prints the version of this build")
 
@@ -52,6 +58,7 @@ func (cmdLineEnv *CmdLineEnv) parseFlagsAndArgs() {
 
 	cmdLineEnv.args = flag.Args()
 	cmdLineEnv.server = *server
+	cmdLineEnv.token = *token
 	cmdLineEnv.injson = *injson
 	cmdLineEnv.ver = *ver
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+
+	"github.com/popura-network/Popura/src/events"
+)
+
+// defaultWatchEvents are watched unless others are given.
+const defaultWatchEvents = "peers,sessions,autopeering"
+
+// pollWait is how long each getEvents request waits for events, in seconds.
+const pollWait = 30
+
+// streamEvents fetches the events of the subscription in the response recv to
+// a subscribe request, over the same connection, and prints each as a line
+// of JSON or, if pretty is set, of text. It only returns once the connection
+// is closed or the subscription ends. A token is sent with every request.
+func streamEvents(encoder *json.Encoder, decoder *json.Decoder, recv *admin.AdminSocketResponse, token string, pretty bool) int {
+	var sub events.SubscribeResponse
+	if err := json.Unmarshal(recv.Response, &sub); err != nil {
+		panic(err)
+	}
+	if pretty {
+		fmt.Println("Watching for", strings.Join(sub.Events, ", "))
+	}
+	args := map[string]interface{}{"subscription": sub.Subscription, "wait": pollWait}
+	if token != "" {
+		args["token"] = token
+	}
+	arguments, err := json.Marshal(args)
+	if err != nil {
+		panic(err)
+	}
+	send := &admin.AdminSocketRequest{Name: "getEvents", Arguments: arguments, KeepAlive: true}
+	out := json.NewEncoder(os.Stdout)
+	for {
+		var resp admin.AdminSocketResponse
+		if err := encoder.Encode(send); err == nil {
+			err = decoder.Decode(&resp)
+		}
+		if err != nil {
+			fmt.Println("Lost the connection to the admin socket:", err)
+			return 1
+		}
+		if resp.Status != "success" {
+			if resp.Error == "" {
+				resp.Error = "the subscription has ended"
+			}
+			fmt.Println("Admin socket returned an error:", resp.Error)
+			return 1
+		}
+		var got events.GetEventsResponse
+		if err := json.Unmarshal(resp.Response, &got); err != nil {
+			panic(err)
+		}
+		for _, e := range got.Events {
+			if pretty {
+				fmt.Println(formatEvent(e))
+			} else if err := out.Encode(e); err != nil {
+				return 1
+			}
+		}
+	}
+}
+
+// formatEvent returns a line of text describing e.
+func formatEvent(e events.Event) string {
+	keys := make([]string, 0, len(e.Data))
+	for key := range e.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	line := fmt.Sprintf("%s  %-24s", e.Time.Local().Format("2006-01-02 15:04:05"), e.Type)
+	for _, key := range keys {
+		line += fmt.Sprintf("  %s=%s", key, e.Data[key])
+	}
+	return line
+}
@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,6 +20,10 @@ import (
 	"github.com/yggdrasil-network/yggdrasil-go/src/multicast"
 	"github.com/yggdrasil-network/yggdrasil-go/src/tun"
 	"github.com/yggdrasil-network/yggdrasil-go/src/version"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/meshname"
+	"github.com/popura-network/Popura/src/shaping"
 )
 
 func main() {
@@ -104,6 +109,20 @@ func run() int {
 			args[tokens[0]] = tokens[1]
 		}
 	}
+	// watch is subscribe with the events printed as text rather than JSON.
+	watch := strings.EqualFold(send.Name, "watch")
+	if watch {
+		send.Name = "subscribe"
+		if _, ok := args["events"]; !ok {
+			args["events"] = defaultWatchEvents
+		}
+	}
+	// The events of a subscription are fetched over the same connection.
+	subscribe := strings.EqualFold(send.Name, "subscribe")
+	send.KeepAlive = subscribe
+	if cmdLineEnv.token != "" {
+		args["token"] = cmdLineEnv.token
+	}
 	if send.Arguments, err = json.Marshal(args); err != nil {
 		panic(err)
 	}
@@ -122,6 +141,9 @@ func run() int {
 		}
 		return 1
 	}
+	if subscribe {
+		return streamEvents(encoder, decoder, recv, cmdLineEnv.token, watch)
+	}
 	if cmdLineEnv.injson {
 		if json, err := json.MarshalIndent(recv.Response, "", "  "); err == nil {
 			fmt.Println(string(json))
@@ -169,12 +191,12 @@ func run() int {
 		table.Append([]string{"Public key:", resp.PublicKey})
 		table.Render()
 
-	case "getpeers":
-		var resp admin.GetPeersResponse
+	case "getpeers", "getshaping":
+		var resp shaping.GetShapingResponse
 		if err := json.Unmarshal(recv.Response, &resp); err != nil {
 			panic(err)
 		}
-		table.SetHeader([]string{"Port", "Public Key", "IP Address", "Uptime", "RX", "TX", "Pr", "URI"})
+		table.SetHeader([]string{"Port", "Public Key", "IP Address", "Uptime", "RX", "TX", "Pr", "URI", "Limits"})
 		for _, peer := range resp.Peers {
 			table.Append([]string{
 				fmt.Sprintf("%d", peer.Port),
@@ -185,6 +207,7 @@ func run() int {
 				peer.TXBytes.String(),
 				fmt.Sprintf("%d", peer.Priority),
 				peer.Remote,
+				formatLimits(peer),
 			})
 		}
 		table.Render()
@@ -270,6 +293,77 @@ func run() int {
 		}
 		table.Render()
 
+	case "getmeshname":
+		var resp meshname.GetMeshnameResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		table.Append([]string{"Meshname enabled:", fmt.Sprintf("%#v", resp.Enabled)})
+		if resp.Enabled {
+			table.Append([]string{"Started:", fmt.Sprintf("%#v", resp.Started)})
+			table.Append([]string{"Listen address:", resp.Listen})
+			if resp.TLSListen != "" {
+				table.Append([]string{"DNS-over-TLS address:", resp.TLSListen})
+			}
+			if resp.HTTPSListen != "" {
+				table.Append([]string{"DNS-over-HTTPS address:", resp.HTTPSListen})
+			}
+			if resp.ZoneListen != "" {
+				table.Append([]string{"Zone address:", resp.ZoneListen})
+				table.Append([]string{"Zone domains:", strings.Join(resp.Zone, ", ")})
+			}
+		}
+		table.Append([]string{"Queries served:", fmt.Sprintf("%d", resp.Queries)})
+		table.Append([]string{"Errors:", fmt.Sprintf("%d", resp.Errors)})
+		table.Append([]string{"Rate limited:", fmt.Sprintf("%d", resp.RateLimited)})
+		if resp.Clients > 0 {
+			table.Append([]string{"Tracked clients:", fmt.Sprintf("%d", resp.Clients)})
+		}
+		table.Render()
+
+	case "meshnameresolve":
+		var resp meshname.MeshnameResolveResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		if len(resp.Records) == 0 {
+			fmt.Printf("No %s records found for %s (%s)\n", resp.Type, resp.Name, resp.Rcode)
+			break
+		}
+		for _, rr := range resp.Records {
+			fmt.Println(rr)
+		}
+
+	case "meshnameencode":
+		var resp meshname.MeshnameEncodeResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		fmt.Println(resp.Name)
+
+	case "meshnamedecode":
+		var resp meshname.MeshnameDecodeResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		fmt.Println(resp.IP)
+
+	case "getloglevels", "setloglevel":
+		var resp logging.GetLogLevelsResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		modules := make([]string, 0, len(resp.Levels))
+		for module := range resp.Levels {
+			modules = append(modules, module)
+		}
+		sort.Strings(modules)
+		table.SetHeader([]string{"Module", "Level"})
+		for _, module := range modules {
+			table.Append([]string{module, resp.Levels[module]})
+		}
+		table.Render()
+
 	case "addpeer", "removepeer":
 
 	default:
@@ -278,3 +372,19 @@ func run() int {
 
 	return 0
 }
+
+// formatLimits describes the limits of a peer connected through the shaper,
+// e.g. "up  1mb/s, down 500kb/s, throttled".
+func formatLimits(peer shaping.PeerEntry) string {
+	var limits []string
+	if peer.UploadLimit != 0 {
+		limits = append(limits, "up "+peer.UploadLimit.String()+"/s")
+	}
+	if peer.DownloadLimit != 0 {
+		limits = append(limits, "down "+peer.DownloadLimit.String()+"/s")
+	}
+	if peer.Throttled {
+		limits = append(limits, "throttled")
+	}
+	return strings.Join(limits, ", ")
+}
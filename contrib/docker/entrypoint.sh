@@ -4,10 +4,13 @@ set -e
 
 CONF_DIR="/etc/yggdrasil-network"
 
+# Rather than editing the generated configuration, options can be overridden
+# with POPURA_* environment variables, e.g. POPURA_PEERS, POPURA_LISTEN or
+# POPURA_MESHNAME_ENABLE, and the private key can be given in
+# POPURA_PRIVATE_KEY. Arguments are passed on, e.g. -printeffectiveconf.
 if [ ! -f "$CONF_DIR/config.conf" ]; then
   echo "generate $CONF_DIR/config.conf"
-  yggdrasil --genconf > "$CONF_DIR/config.conf"
+  yggdrasil -genconf > "$CONF_DIR/config.conf"
 fi
 
-yggdrasil --useconf < "$CONF_DIR/config.conf"
-exit $?
+exec yggdrasil -useconffile "$CONF_DIR/config.conf" "$@"
@@ -18,6 +18,8 @@ import (
 	"github.com/yggdrasil-network/yggdrasil-go/src/version"
 
 	_ "golang.org/x/mobile/bind"
+
+	"github.com/popura-network/Popura/src/popura"
 )
 
 // Yggdrasil mobile package is meant to "plug the gap" for mobile support, as
@@ -49,6 +51,14 @@ func (m *Yggdrasil) StartJSON(configjson []byte) error {
 	if err := json.Unmarshal(configjson, &m.config); err != nil {
 		return err
 	}
+	// Take the private key from PrivateKeyPath or the environment, if given.
+	var keyConfig struct{ PrivateKeyPath string }
+	if err := json.Unmarshal(configjson, &keyConfig); err != nil {
+		return err
+	}
+	if source, err := popura.ResolvePrivateKey(m.config, keyConfig.PrivateKeyPath, logger); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
 	// Setup the Yggdrasil node itself.
 	{
 		sk, err := hex.DecodeString(m.config.PrivateKey)
@@ -0,0 +1,236 @@
+package accounting
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+const defaultTrafficDays = 30
+
+type GetTrafficRequest struct {
+	Days string `json:"days"`
+	Key  string `json:"key"`
+}
+type GetTrafficResponse struct {
+	Since    string         `json:"since"`
+	Sessions []TrafficEntry `json:"sessions"`
+	Peers    []TrafficEntry `json:"peers"`
+	Daily    []DailyEntry   `json:"daily,omitempty"`
+}
+
+// TrafficEntry is the traffic of a session, or of a peer link if Remote is
+// set. Packets are only counted for sessions.
+type TrafficEntry struct {
+	Key       string `json:"key"`
+	Address   string `json:"address"`
+	Remote    string `json:"remote,omitempty"`
+	RXBytes   uint64 `json:"bytes_recvd"`
+	TXBytes   uint64 `json:"bytes_sent"`
+	RXPackets uint64 `json:"packets_recvd,omitempty"`
+	TXPackets uint64 `json:"packets_sent,omitempty"`
+	LastDay   string `json:"last_day"`
+	TotalRX   uint64 `json:"total_bytes_recvd"`
+	TotalTX   uint64 `json:"total_bytes_sent"`
+}
+
+// DailyEntry is the traffic of a single key on one day, in sessions and over
+// peer links.
+type DailyEntry struct {
+	Date        string `json:"date"`
+	RXBytes     uint64 `json:"bytes_recvd"`
+	TXBytes     uint64 `json:"bytes_sent"`
+	RXPackets   uint64 `json:"packets_recvd"`
+	TXPackets   uint64 `json:"packets_sent"`
+	PeerRXBytes uint64 `json:"peer_bytes_recvd"`
+	PeerTXBytes uint64 `json:"peer_bytes_sent"`
+}
+
+type ResetTrafficRequest struct {
+	Key string `json:"key"`
+}
+type ResetTrafficResponse struct{}
+
+// getTrafficHandler returns the traffic of each key and peer link over the
+// last days, from most to least bytes, and the traffic of each day for a
+// single key if one is given.
+func (a *Accounting) getTrafficHandler(req *GetTrafficRequest, res *GetTrafficResponse) error {
+	days := defaultTrafficDays
+	if req.Days != "" {
+		var err error
+		if days, err = strconv.Atoi(req.Days); err != nil || days < 1 {
+			return errors.New("days must be a positive number")
+		}
+	}
+	key := strings.ToLower(req.Key)
+	if key != "" {
+		if b, err := hex.DecodeString(key); err != nil || len(b) != 32 {
+			return errors.New("key must be a public key in hex")
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.state == nil {
+		return errors.New("accounting is not enabled")
+	}
+	now := time.Now()
+	if a.done != nil {
+		a.sample(now)
+	}
+	since := now.UTC().AddDate(0, 0, 1-days).Format(dayFormat)
+	res.Since = since
+	sessions := make(map[string]*TrafficEntry)
+	peers := make(map[string]*TrafficEntry)
+	for _, date := range a.state.dates() {
+		if date < since {
+			continue
+		}
+		d := a.state.Days[date]
+		daily := DailyEntry{Date: date}
+		for k, c := range d.Keys {
+			if key != "" && k != key {
+				continue
+			}
+			e, ok := sessions[k]
+			if !ok {
+				e = newEntry(k, "")
+				sessions[k] = e
+			}
+			e.add(date, c)
+			daily.RXBytes += c.RX
+			daily.TXBytes += c.TX
+			daily.RXPackets += c.RXPackets
+			daily.TXPackets += c.TXPackets
+		}
+		for link, c := range d.Peers {
+			k, remote := splitLinkID(link)
+			if key != "" && k != key {
+				continue
+			}
+			e, ok := peers[link]
+			if !ok {
+				e = newEntry(k, remote)
+				peers[link] = e
+			}
+			e.add(date, c)
+			daily.PeerRXBytes += c.RX
+			daily.PeerTXBytes += c.TX
+		}
+		if key != "" {
+			res.Daily = append(res.Daily, daily)
+		}
+	}
+	res.Sessions = sortEntries(sessions, a.state.Totals.Keys, func(e *TrafficEntry) string { return e.Key })
+	res.Peers = sortEntries(peers, a.state.Totals.Peers, func(e *TrafficEntry) string { return e.Key + "@" + e.Remote })
+	return nil
+}
+
+func (a *Accounting) resetTrafficHandler(req *ResetTrafficRequest, res *ResetTrafficResponse) error {
+	key := strings.ToLower(req.Key)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.state == nil {
+		return errors.New("accounting is not enabled")
+	}
+	if key == "" {
+		a.state = newState()
+	} else {
+		days := []*Day{a.state.Totals}
+		for _, d := range a.state.Days {
+			days = append(days, d)
+		}
+		for _, d := range days {
+			delete(d.Keys, key)
+			for link := range d.Peers {
+				if k, _ := splitLinkID(link); k == key {
+					delete(d.Peers, link)
+				}
+			}
+		}
+	}
+	return a.save(time.Now())
+}
+
+func newEntry(key, remote string) *TrafficEntry {
+	e := &TrafficEntry{Key: key, Remote: remote}
+	if b, err := hex.DecodeString(key); err == nil && len(b) == 32 {
+		addr := address.AddrForKey(b)
+		e.Address = net.IP(addr[:]).String()
+	}
+	return e
+}
+
+func (e *TrafficEntry) add(date string, c *Counters) {
+	e.RXBytes += c.RX
+	e.TXBytes += c.TX
+	e.RXPackets += c.RXPackets
+	e.TXPackets += c.TXPackets
+	e.LastDay = date
+}
+
+// sortEntries returns entries from most to least bytes, with their totals.
+func sortEntries(entries map[string]*TrafficEntry, totals map[string]*Counters, id func(*TrafficEntry) string) []TrafficEntry {
+	list := make([]TrafficEntry, 0, len(entries))
+	for _, e := range entries {
+		if t, ok := totals[id(e)]; ok {
+			e.TotalRX, e.TotalTX = t.RX, t.TX
+		}
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		bi, bj := list[i].RXBytes+list[i].TXBytes, list[j].RXBytes+list[j].TXBytes
+		if bi != bj {
+			return bi > bj
+		}
+		return id(&list[i]) < id(&list[j])
+	})
+	return list
+}
+
+// splitLinkID returns the public key and remote of a peer link.
+func splitLinkID(link string) (key, remote string) {
+	if i := strings.IndexByte(link, '@'); i >= 0 {
+		return link[:i], link[i+1:]
+	}
+	return link, ""
+}
+
+func (a *Accounting) SetupAdminHandlers(s *admin.AdminSocket) {
+	_ = s.AddHandler(
+		"getTraffic", "Show the traffic of each session and peer link over the last days, or of each day for a key", []string{"[days]", "[key]"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &GetTrafficRequest{}
+			res := &GetTrafficResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := a.getTrafficHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = s.AddHandler(
+		"resetTraffic", "Forget the traffic counted for a key, or for all keys if none is given", []string{"[key]"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &ResetTrafficRequest{}
+			res := &ResetTrafficResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := a.resetTrafficHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+}
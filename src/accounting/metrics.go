@@ -0,0 +1,76 @@
+package accounting
+
+import (
+	"sort"
+	"time"
+
+	"github.com/popura-network/Popura/src/metrics"
+)
+
+// WriteMetrics writes the traffic counted in all sessions and over all peer
+// links, and if MetricsKeys is set the traffic of the keys and peer links with
+// the most, since each was first seen. A key or peer link is kept for as long
+// as any of its daily counts are.
+func (a *Accounting) WriteMetrics(w *metrics.Writer) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.state == nil {
+		return
+	}
+	if a.done != nil {
+		a.sample(time.Now())
+	}
+	s := a.state
+	w.Counter("popura_traffic_session_rx_bytes_total", "Bytes received in sessions, kept across restarts.", float64(s.Sessions.RX))
+	w.Counter("popura_traffic_session_tx_bytes_total", "Bytes sent in sessions, kept across restarts.", float64(s.Sessions.TX))
+	w.Counter("popura_traffic_session_rx_packets_total", "Packets received in sessions and passed to the TUN adapter, kept across restarts.", float64(s.Sessions.RXPackets))
+	w.Counter("popura_traffic_session_tx_packets_total", "Packets from the TUN adapter sent in sessions, kept across restarts.", float64(s.Sessions.TXPackets))
+	w.Counter("popura_traffic_peer_rx_bytes_total", "Bytes received over peer links, kept across restarts.", float64(s.Peers.RX))
+	w.Counter("popura_traffic_peer_tx_bytes_total", "Bytes sent over peer links, kept across restarts.", float64(s.Peers.TX))
+	if a.config.MetricsKeys == 0 {
+		return
+	}
+
+	keys := top(s.Totals.Keys, a.config.MetricsKeys)
+	for _, key := range keys {
+		w.Counter("popura_traffic_key_rx_bytes_total", "Bytes received in sessions with a node, kept across restarts.", float64(s.Totals.Keys[key].RX), "key", key)
+	}
+	for _, key := range keys {
+		w.Counter("popura_traffic_key_tx_bytes_total", "Bytes sent in sessions with a node, kept across restarts.", float64(s.Totals.Keys[key].TX), "key", key)
+	}
+	for _, key := range keys {
+		w.Counter("popura_traffic_key_rx_packets_total", "Packets received in sessions with a node, kept across restarts.", float64(s.Totals.Keys[key].RXPackets), "key", key)
+	}
+	for _, key := range keys {
+		w.Counter("popura_traffic_key_tx_packets_total", "Packets sent in sessions with a node, kept across restarts.", float64(s.Totals.Keys[key].TXPackets), "key", key)
+	}
+	links := top(s.Totals.Peers, a.config.MetricsKeys)
+	for _, link := range links {
+		key, remote := splitLinkID(link)
+		w.Counter("popura_traffic_link_rx_bytes_total", "Bytes received over a peer link, kept across restarts.", float64(s.Totals.Peers[link].RX), "key", key, "remote", remote)
+	}
+	for _, link := range links {
+		key, remote := splitLinkID(link)
+		w.Counter("popura_traffic_link_tx_bytes_total", "Bytes sent over a peer link, kept across restarts.", float64(s.Totals.Peers[link].TX), "key", key, "remote", remote)
+	}
+}
+
+// top returns the ids of at most n counters with the most bytes, from most to
+// least.
+func top(counters map[string]*Counters, n int) []string {
+	ids := make([]string, 0, len(counters))
+	for id := range counters {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		ci, cj := counters[ids[i]], counters[ids[j]]
+		if bi, bj := ci.RX+ci.TX, cj.RX+cj.TX; bi != bj {
+			return bi > bj
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	return ids
+}
@@ -0,0 +1,236 @@
+package accounting
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+const (
+	sampleInterval = 10 * time.Second
+	saveInterval   = 5 * time.Minute
+)
+
+// counted is the last sample of the counters of a session or peer
+// connection, which the core resets when it reconnects.
+type counted struct {
+	rx, tx uint64
+	uptime time.Duration
+}
+
+// Accounting keeps daily counts of the traffic of each session and peer
+// link, which outlive the sessions and connections themselves. The core only
+// reports bytes, so the packets of sessions are counted on their way to and
+// from the TUN adapter, and those of peer links aren't counted.
+type Accounting struct {
+	core       *core.Core
+	log        *logging.Logger
+	lister     popura.PeerLister
+	config     popura.AccountingConfig
+	state      *state
+	sessions   map[string]counted // by public key
+	peers      map[string]counted // by public key and remote address
+	packets    map[remote]Counters
+	packetLock sync.Mutex
+	saved      time.Time
+	done       chan struct{}
+	finished   chan struct{}
+	lock       sync.Mutex
+}
+
+// Init takes the PeerLister to list peers with as its options, or uses the
+// core if it is nil.
+func (a *Accounting) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
+	a.core = yggcore
+	a.log = log
+	a.lister, _ = options.(popura.PeerLister)
+	if a.lister == nil {
+		a.lister = yggcore
+	}
+	a.config = popConfig.Accounting
+	if !a.config.Enable {
+		return nil
+	}
+	if a.config.StateDir == "" {
+		return errors.New("StateDir must be set")
+	}
+	if a.config.Days < 1 {
+		return errors.New("Days must be at least 1")
+	}
+	if a.config.MetricsKeys < 0 {
+		return errors.New("MetricsKeys must not be negative")
+	}
+	if err := os.MkdirAll(a.config.StateDir, 0700); err != nil {
+		return err
+	}
+	s, err := load(a.config.StateDir)
+	if err != nil {
+		return err
+	}
+	a.state = s
+	a.sessions = make(map[string]counted)
+	a.peers = make(map[string]counted)
+	a.packets = make(map[remote]Counters)
+	return nil
+}
+
+func (a *Accounting) Start() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.config.Enable {
+		return nil
+	}
+	if a.done != nil {
+		return errors.New("already started")
+	}
+	a.done = make(chan struct{})
+	a.finished = make(chan struct{})
+	a.saved = time.Now()
+	go a.run(a.done, a.finished)
+	a.log.Infoln("accounting: counting traffic in", a.config.StateDir)
+	return nil
+}
+
+func (a *Accounting) Stop() error {
+	a.lock.Lock()
+	if a.done == nil {
+		a.lock.Unlock()
+		return nil
+	}
+	close(a.done)
+	finished := a.finished
+	a.done = nil
+	a.lock.Unlock()
+	<-finished
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.sample(time.Now())
+	return a.save(time.Now())
+}
+
+func (a *Accounting) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
+
+func (a *Accounting) IsStarted() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.done != nil
+}
+
+func (a *Accounting) Health() error {
+	if a.config.Enable && !a.IsStarted() {
+		return errors.New("accounting is not running")
+	}
+	return nil
+}
+
+func (a *Accounting) run(done, finished chan struct{}) {
+	defer close(finished)
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			a.lock.Lock()
+			a.sample(now)
+			if now.Sub(a.saved) >= saveInterval {
+				if err := a.save(now); err != nil {
+					a.log.Errorln("accounting: failed to save the counters:", err)
+				}
+			}
+			a.lock.Unlock()
+		}
+	}
+}
+
+// sample adds the traffic since the last sample to the bucket of the day.
+// Traffic of sessions and connections that ended since then is lost, as are
+// packets to and from nodes without a session. lock must be held.
+func (a *Accounting) sample(now time.Time) {
+	day := a.state.day(now)
+	packets := a.takePackets()
+	sessions := make(map[string]counted)
+	for _, s := range a.core.GetSessions() {
+		key := hex.EncodeToString(s.Key)
+		c := counted{s.RXBytes, s.TXBytes, s.Uptime}
+		var d Counters
+		d.RX, d.TX = delta(a.sessions[key], c)
+		addr, subnet := remotesOf(s.Key)
+		d.RXPackets = packets[addr].RXPackets + packets[subnet].RXPackets
+		d.TXPackets = packets[addr].TXPackets + packets[subnet].TXPackets
+		add(day.Keys, key, d)
+		add(a.state.Totals.Keys, key, d)
+		a.state.Sessions.add(d)
+		sessions[key] = c
+	}
+	a.sessions = sessions
+	peers := make(map[string]counted)
+	for _, p := range a.lister.GetPeers() {
+		key := hex.EncodeToString(p.Key)
+		c := counted{p.RXBytes, p.TXBytes, p.Uptime}
+		var d Counters
+		d.RX, d.TX = delta(a.peers[key+"@"+p.Remote], c)
+		link := linkID(key, p.Remote)
+		add(day.Peers, link, d)
+		add(a.state.Totals.Peers, link, d)
+		a.state.Peers.add(d)
+		peers[key+"@"+p.Remote] = c
+	}
+	a.peers = peers
+}
+
+// save prunes old buckets and writes the counters to the state file. lock
+// must be held.
+func (a *Accounting) save(now time.Time) error {
+	a.state.prune(now, a.config.Days)
+	a.saved = now
+	return a.state.save(a.config.StateDir)
+}
+
+// delta returns the bytes counted since the last sample. A session or
+// connection that has been up for less time than at the last sample is a new
+// one, with counters starting from zero.
+func delta(last, c counted) (rx, tx uint64) {
+	if c.uptime < last.uptime || c.rx < last.rx || c.tx < last.tx {
+		return c.rx, c.tx
+	}
+	return c.rx - last.rx, c.tx - last.tx
+}
+
+func add(m map[string]*Counters, id string, d Counters) {
+	if d.zero() {
+		return
+	}
+	c, ok := m[id]
+	if !ok {
+		c = &Counters{}
+		m[id] = c
+	}
+	c.add(d)
+}
+
+// linkID identifies a peer link by the public key of the peer and the host it
+// is connected to, without the port, which differs for each incoming
+// connection.
+func linkID(key, remote string) string {
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		host := u.Hostname()
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		remote = u.Scheme + "://" + host
+	}
+	return key + "@" + remote
+}
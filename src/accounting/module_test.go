@@ -0,0 +1,157 @@
+package accounting
+
+import (
+	"crypto/ed25519"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDelta(t *testing.T) {
+	tests := []struct {
+		name   string
+		last   counted
+		c      counted
+		rx, tx uint64
+	}{
+		{"first sample", counted{}, counted{100, 200, time.Minute}, 100, 200},
+		{"same session", counted{100, 200, time.Minute}, counted{150, 260, 2 * time.Minute}, 50, 60},
+		{"no traffic", counted{100, 200, time.Minute}, counted{100, 200, 2 * time.Minute}, 0, 0},
+		{"reconnected", counted{100, 200, time.Minute}, counted{300, 400, 10 * time.Second}, 300, 400},
+		{"reconnected with less uptime and traffic", counted{100, 200, time.Minute}, counted{10, 20, time.Second}, 10, 20},
+		{"counter went back", counted{100, 200, time.Minute}, counted{50, 300, 2 * time.Minute}, 50, 300},
+	}
+	for _, test := range tests {
+		rx, tx := delta(test.last, test.c)
+		if rx != test.rx || tx != test.tx {
+			t.Errorf("%s: got %d, %d, want %d, %d", test.name, rx, tx, test.rx, test.tx)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	newTestState := func() *state {
+		s := newState()
+		for date, keys := range map[string][]string{
+			"2022-03-01": {"a"},
+			"2022-03-08": {"b"},
+			"2022-03-09": {"b", "c"},
+			"2022-03-10": {"c"},
+		} {
+			d := newDay()
+			for _, key := range keys {
+				add(d.Keys, key, Counters{RX: 1})
+				add(d.Peers, key+"@tcp://[::1]", Counters{RX: 1})
+				add(s.Totals.Keys, key, Counters{RX: 1})
+				add(s.Totals.Peers, key+"@tcp://[::1]", Counters{RX: 1})
+			}
+			s.Days[date] = d
+		}
+		s.Sessions = Counters{RX: 5}
+		return s
+	}
+	tests := []struct {
+		days  int
+		dates []string
+		keys  []string
+	}{
+		{1, []string{"2022-03-10"}, []string{"c"}},
+		{2, []string{"2022-03-09", "2022-03-10"}, []string{"b", "c"}},
+		{3, []string{"2022-03-08", "2022-03-09", "2022-03-10"}, []string{"b", "c"}},
+		{10, []string{"2022-03-01", "2022-03-08", "2022-03-09", "2022-03-10"}, []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		s := newTestState()
+		s.prune(now, test.days)
+		if dates := s.dates(); !reflect.DeepEqual(dates, test.dates) {
+			t.Errorf("%d days: got dates %v, want %v", test.days, dates, test.dates)
+		}
+		if keys := sortedKeys(s.Totals.Keys); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%d days: got keys %v, want %v", test.days, keys, test.keys)
+		}
+		var links []string
+		for _, key := range test.keys {
+			links = append(links, key+"@tcp://[::1]")
+		}
+		if got := sortedKeys(s.Totals.Peers); !reflect.DeepEqual(got, links) {
+			t.Errorf("%d days: got peer links %v, want %v", test.days, got, links)
+		}
+		if s.Sessions.RX != 5 {
+			t.Errorf("%d days: the sum of sessions was pruned", test.days)
+		}
+	}
+}
+
+func TestTop(t *testing.T) {
+	counters := map[string]*Counters{
+		"a": {RX: 1, TX: 1},
+		"b": {RX: 10},
+		"c": {TX: 5},
+		"d": {RX: 5},
+	}
+	tests := map[int][]string{
+		1:  {"b"},
+		3:  {"b", "c", "d"},
+		10: {"b", "c", "d", "a"},
+	}
+	for n, want := range tests {
+		if got := top(counters, n); !reflect.DeepEqual(got, want) {
+			t.Errorf("top %d: got %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestCountPacket(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, subnet := remotesOf(pub)
+	host := subnet
+	host[15] = 1
+	packet := func(src, dst []byte) []byte {
+		bs := make([]byte, 48)
+		bs[0] = 0x60
+		copy(bs[8:24], src)
+		copy(bs[24:40], dst)
+		return bs
+	}
+	local := make([]byte, 16)
+	local[0] = 0x02
+	other := []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+
+	a := &Accounting{packets: make(map[remote]Counters)}
+	a.countPacket(packet(addr[:], local), false)
+	a.countPacket(packet(addr[:], local), false)
+	a.countPacket(packet(local, addr[:]), true)
+	a.countPacket(packet(host[:], local), false)
+	a.countPacket(packet(local, host[:]), true)
+	a.countPacket(packet(local, host[:]), true)
+	a.countPacket(packet(other, local), false)
+	a.countPacket(packet(addr[:], local)[:39], false)
+	v4 := packet(addr[:], local)
+	v4[0] = 0x45
+	a.countPacket(v4, false)
+
+	want := map[remote]Counters{
+		addr:   {RXPackets: 2, TXPackets: 1},
+		subnet: {RXPackets: 1, TXPackets: 2},
+	}
+	if got := a.takePackets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := a.takePackets(); len(got) != 0 {
+		t.Errorf("got %v after taking the packets", got)
+	}
+}
+
+func sortedKeys(m map[string]*Counters) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,100 @@
+package accounting
+
+import (
+	"crypto/ed25519"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+
+	"github.com/popura-network/Popura/src/tun"
+)
+
+// remote is the address of the node at the other end of a packet, or its
+// subnet followed by zeros.
+type remote [16]byte
+
+// remoteOf returns the remote of the IPv6 address ip, and whether it belongs
+// to a node at all.
+func remoteOf(ip []byte) (r remote, ok bool) {
+	var addr address.Address
+	copy(addr[:], ip)
+	if addr.IsValid() {
+		return remote(addr), true
+	}
+	var subnet address.Subnet
+	copy(subnet[:], ip)
+	if subnet.IsValid() {
+		copy(r[:], subnet[:])
+		return r, true
+	}
+	return r, false
+}
+
+// remotesOf returns the remotes of the address and subnet of key.
+func remotesOf(key ed25519.PublicKey) (addr, subnet remote) {
+	addr = remote(*address.AddrForKey(key))
+	copy(subnet[:], address.SubnetForKey(key)[:])
+	return addr, subnet
+}
+
+// countPacket counts a packet received from the network, or sent to it if tx
+// is set, by its remote.
+func (a *Accounting) countPacket(bs []byte, tx bool) {
+	if len(bs) < 40 || bs[0]>>4 != 6 {
+		return
+	}
+	ip := bs[8:24]
+	if tx {
+		ip = bs[24:40]
+	}
+	r, ok := remoteOf(ip)
+	if !ok {
+		return
+	}
+	a.packetLock.Lock()
+	defer a.packetLock.Unlock()
+	c := a.packets[r]
+	if tx {
+		c.TXPackets++
+	} else {
+		c.RXPackets++
+	}
+	a.packets[r] = c
+}
+
+// takePackets returns the packets counted since it was last called.
+func (a *Accounting) takePackets() map[remote]Counters {
+	a.packetLock.Lock()
+	defer a.packetLock.Unlock()
+	packets := a.packets
+	a.packets = make(map[remote]Counters)
+	return packets
+}
+
+// ReadWriteCloser returns rwc with the packets passed through it counted, or
+// rwc itself if accounting isn't enabled.
+func (a *Accounting) ReadWriteCloser(rwc tun.ReadWriteCloser) tun.ReadWriteCloser {
+	if !a.config.Enable {
+		return rwc
+	}
+	return &counter{ReadWriteCloser: rwc, accounting: a}
+}
+
+// counter is a tun.ReadWriteCloser that counts the packets read from and
+// written to the network.
+type counter struct {
+	tun.ReadWriteCloser
+	accounting *Accounting
+}
+
+func (c *counter) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if err == nil {
+		c.accounting.countPacket(p[:n], false)
+	}
+	return n, err
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	c.accounting.countPacket(p, true)
+	return c.ReadWriteCloser.Write(p)
+}
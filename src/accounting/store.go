@@ -0,0 +1,176 @@
+package accounting
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// stateFile is the name of the file in the state directory holding the
+// counters.
+const stateFile = "traffic.json"
+
+// dayFormat is the format of the dates of daily buckets, which are in UTC.
+const dayFormat = "2006-01-02"
+
+// Counters are the bytes received from and sent to a node or peer, and for
+// nodes the packets passed to and from the TUN adapter.
+type Counters struct {
+	RX        uint64 `json:"rx"`
+	TX        uint64 `json:"tx"`
+	RXPackets uint64 `json:"rx_packets,omitempty"`
+	TXPackets uint64 `json:"tx_packets,omitempty"`
+}
+
+func (c *Counters) add(d Counters) {
+	c.RX += d.RX
+	c.TX += d.TX
+	c.RXPackets += d.RXPackets
+	c.TXPackets += d.TXPackets
+}
+
+func (c *Counters) zero() bool {
+	return c.RX == 0 && c.TX == 0 && c.RXPackets == 0 && c.TXPackets == 0
+}
+
+// Day holds the traffic of one day, by public key in hex for sessions and by
+// peer link for peers.
+type Day struct {
+	Keys  map[string]*Counters `json:"keys"`
+	Peers map[string]*Counters `json:"peers"`
+}
+
+func newDay() *Day {
+	d := &Day{}
+	d.init()
+	return d
+}
+
+// init creates the maps of a day read from the state file without them.
+func (d *Day) init() {
+	if d.Keys == nil {
+		d.Keys = make(map[string]*Counters)
+	}
+	if d.Peers == nil {
+		d.Peers = make(map[string]*Counters)
+	}
+}
+
+// state is what is saved in the state file.
+type state struct {
+	Days   map[string]*Day `json:"days"`
+	Totals *Day            `json:"totals"` // since each key was first seen
+	// Sums of the traffic of all sessions and peer links, which unlike the
+	// totals aren't pruned.
+	Sessions Counters `json:"sessions"`
+	Peers    Counters `json:"peers"`
+}
+
+func newState() *state {
+	return &state{Days: make(map[string]*Day), Totals: newDay()}
+}
+
+// day returns the bucket of the day of t, creating it if needed.
+func (s *state) day(t time.Time) *Day {
+	date := t.UTC().Format(dayFormat)
+	d, ok := s.Days[date]
+	if !ok {
+		d = newDay()
+		s.Days[date] = d
+	}
+	return d
+}
+
+// dates returns the dates of the buckets from oldest to newest.
+func (s *state) dates() []string {
+	dates := make([]string, 0, len(s.Days))
+	for date := range s.Days {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	return dates
+}
+
+// prune removes the buckets older than days days before now, and the totals
+// of keys and peers that are in none of the buckets left.
+func (s *state) prune(now time.Time, days int) {
+	oldest := now.UTC().AddDate(0, 0, 1-days).Format(dayFormat)
+	for date := range s.Days {
+		if date < oldest {
+			delete(s.Days, date)
+		}
+	}
+	seen := newDay()
+	for _, d := range s.Days {
+		for key := range d.Keys {
+			seen.Keys[key] = nil
+		}
+		for peer := range d.Peers {
+			seen.Peers[peer] = nil
+		}
+	}
+	for key := range s.Totals.Keys {
+		if _, ok := seen.Keys[key]; !ok {
+			delete(s.Totals.Keys, key)
+		}
+	}
+	for peer := range s.Totals.Peers {
+		if _, ok := seen.Peers[peer]; !ok {
+			delete(s.Totals.Peers, peer)
+		}
+	}
+}
+
+// load reads the state file in dir. A missing file is an empty state.
+func load(dir string) (*state, error) {
+	s := newState()
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.Days == nil {
+		s.Days = make(map[string]*Day)
+	}
+	if s.Totals == nil {
+		s.Totals = newDay()
+	}
+	s.Totals.init()
+	for _, d := range s.Days {
+		d.init()
+	}
+	return s, nil
+}
+
+// save writes the state file in dir, replacing the old one only once the new
+// one is complete.
+func (s *state) save(dir string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, stateFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, stateFile))
+}
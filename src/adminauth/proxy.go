@@ -0,0 +1,359 @@
+package adminauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+// Roles that can be given to tokens.
+const (
+	RoleAdmin = "admin" // may run every command
+	RoleRead  = "read"  // may only run commands that don't change the node
+)
+
+// Errors returned to clients that aren't allowed to run a command.
+const (
+	ErrUnauthorized = "a valid token is required"
+	ErrForbidden    = "the role of the token doesn't allow this command"
+)
+
+// readCommands are the commands that don't change the node, in lower case.
+// Commands that aren't listed, including those added later, need the admin
+// role.
+var readCommands = map[string]bool{
+	"list":                   true,
+	"getself":                true,
+	"getpeers":               true,
+	"getdht":                 true,
+	"getpaths":               true,
+	"getsessions":            true,
+	"getnodeinfo":            true,
+	"getmulticastinterfaces": true,
+	"gettun":                 true,
+	"getloglevels":           true,
+	"getmeshname":            true,
+	"getautopeering":         true,
+	"gettraffic":             true,
+	"getfirewall":            true,
+	"getshaping":             true,
+	"subscribe":              true,
+	"getevents":              true,
+	"unsubscribe":            true,
+	"meshnameresolve":        true,
+	"meshnameencode":         true,
+	"meshnamedecode":         true,
+}
+
+// ReadOnly reports whether command may be run with the read role.
+func ReadOnly(command string) bool {
+	return readCommands[strings.ToLower(command)]
+}
+
+// request is an admin socket request with the token in its arguments.
+type request struct {
+	Name      string          `json:"request"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	KeepAlive bool            `json:"keepalive,omitempty"`
+}
+
+// Proxy sits in front of the admin socket when tokens are configured. The
+// admin socket then listens on a private socket instead of AdminListen, and
+// the proxy listens on AdminListen and forwards the requests whose tokens
+// allow them to it.
+type Proxy struct {
+	log      *logging.Logger
+	listen   string
+	tokens   []popura.AdminToken
+	dir      string // private directory holding the admin socket
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+	lock     sync.Mutex
+}
+
+func (p *Proxy) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
+	p.log = log
+	p.listen = yggConfig.AdminListen
+	p.tokens = popConfig.AdminAuth.Tokens
+	if !p.Enabled() {
+		return nil
+	}
+	for i, t := range p.tokens {
+		if t.Token == "" {
+			return fmt.Errorf("Tokens[%d] has no Token", i)
+		}
+		if t.Role != RoleAdmin && t.Role != RoleRead {
+			return fmt.Errorf("Tokens[%d] has unknown Role %q, must be %q or %q", i, t.Role, RoleAdmin, RoleRead)
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether the proxy is needed, as the admin socket is
+// enabled and tokens are configured.
+func (p *Proxy) Enabled() bool {
+	return p.listen != "" && p.listen != "none" && len(p.tokens) > 0
+}
+
+// Backend returns the address for the admin socket to listen on, behind the
+// proxy. It is only valid once the proxy has been started.
+func (p *Proxy) Backend() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return "unix://" + filepath.Join(p.dir, "admin.sock")
+}
+
+func (p *Proxy) Start() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.Enabled() {
+		return nil
+	}
+	if p.listener != nil {
+		return errors.New("already started")
+	}
+	dir, err := os.MkdirTemp("", "popura-admin-")
+	if err != nil {
+		return err
+	}
+	listener, err := Listen(p.listen)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	p.dir = dir
+	p.listener = listener
+	p.conns = make(map[net.Conn]struct{})
+	p.wg.Add(1)
+	go p.accept(listener)
+	p.log.Infoln("admin: checking tokens on", listener.Addr())
+	return nil
+}
+
+func (p *Proxy) Stop() error {
+	p.lock.Lock()
+	if p.listener == nil {
+		p.lock.Unlock()
+		return nil
+	}
+	err := p.listener.Close()
+	p.listener = nil
+	dir := p.dir
+	p.dir = ""
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.lock.Unlock()
+	p.wg.Wait()
+	_ = os.RemoveAll(dir)
+	return err
+}
+
+func (p *Proxy) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
+func (p *Proxy) SetupAdminHandlers(a *admin.AdminSocket)                                   {}
+
+func (p *Proxy) IsStarted() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.listener != nil
+}
+
+func (p *Proxy) Health() error {
+	if p.Enabled() && !p.IsStarted() {
+		return errors.New("proxy is not running")
+	}
+	return nil
+}
+
+func (p *Proxy) accept(listener net.Listener) {
+	defer p.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		p.lock.Lock()
+		if p.listener == nil {
+			p.lock.Unlock()
+			conn.Close()
+			return
+		}
+		p.conns[conn] = struct{}{}
+		p.wg.Add(1)
+		p.lock.Unlock()
+		go func() {
+			defer p.wg.Done()
+			p.handle(conn)
+			p.lock.Lock()
+			delete(p.conns, conn)
+			p.lock.Unlock()
+		}()
+	}
+}
+
+// handle serves the requests sent over a connection, which is kept open for
+// as long as the client asks it to be.
+func (p *Proxy) handle(conn net.Conn) {
+	defer conn.Close()
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	encoder.SetIndent("", "  ")
+	var backend net.Conn
+	var backendDecoder *json.Decoder
+	defer func() {
+		if backend != nil {
+			backend.Close()
+		}
+	}()
+
+	for {
+		var req request
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+		var resp json.RawMessage
+		args, err := p.authorize(&req, conn.RemoteAddr())
+		if err == nil {
+			if backend == nil {
+				if backend, err = Dial(context.Background(), p.Backend()); err == nil {
+					backendDecoder = json.NewDecoder(backend)
+				}
+			}
+			if err == nil {
+				forward := &request{Name: req.Name, Arguments: args, KeepAlive: true}
+				if err = json.NewEncoder(backend).Encode(forward); err == nil {
+					err = backendDecoder.Decode(&resp)
+				}
+				if err != nil {
+					p.log.Errorln("admin: lost the connection to the admin socket:", err)
+					backend.Close()
+					backend = nil
+					err = errors.New("admin socket is not available")
+				}
+			}
+		}
+		if err != nil {
+			resp, _ = json.Marshal(&admin.AdminSocketResponse{Status: "error", Error: err.Error()})
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+		if !req.KeepAlive {
+			return
+		}
+	}
+}
+
+// authorize checks the token in the arguments of req, and returns the
+// arguments without it.
+func (p *Proxy) authorize(req *request, remote net.Addr) (json.RawMessage, error) {
+	args := make(map[string]json.RawMessage)
+	if len(req.Arguments) > 0 && string(req.Arguments) != "null" {
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, errors.New("Failed to unmarshal request")
+		}
+	}
+	var token string
+	if raw, ok := args["token"]; ok {
+		_ = json.Unmarshal(raw, &token)
+		delete(args, "token")
+	}
+	if len(p.tokens) == 0 {
+		return json.Marshal(args)
+	}
+	role := p.role(token)
+	switch {
+	case role == "":
+		p.log.Infof("admin: rejected %s from %s without a valid token", req.Name, remote)
+		return nil, errors.New(ErrUnauthorized)
+	case role != RoleAdmin && !ReadOnly(req.Name):
+		p.log.Infof("admin: rejected %s from %s with a %s token", req.Name, remote, role)
+		return nil, errors.New(ErrForbidden)
+	}
+	return json.Marshal(args)
+}
+
+// role returns the role of token, or an empty string if it isn't valid.
+func (p *Proxy) role(token string) string {
+	role := ""
+	for _, t := range p.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 && token != "" {
+			role = t.Role
+		}
+	}
+	return role
+}
+
+// Listen listens on an AdminListen address in the same way as the admin
+// socket does.
+func Listen(listen string) (net.Listener, error) {
+	u, err := url.Parse(listen)
+	if err != nil {
+		return net.Listen("tcp", listen)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "unix":
+		path := listen[7:]
+		if _, err := os.Stat(path); err == nil {
+			// Remove the socket left behind by a node that has gone away.
+			if conn, err := net.DialTimeout("unix", path, 2*time.Second); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("%s is in use by another process", path)
+			}
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		listener, err := net.Listen("unix", path)
+		if err == nil && !strings.HasPrefix(path, "@") {
+			if err := os.Chmod(path, 0660); err != nil {
+				listener.Close()
+				return nil, err
+			}
+		}
+		return listener, err
+	case "tcp":
+		return net.Listen("tcp", u.Host)
+	default:
+		return net.Listen("tcp", listen)
+	}
+}
+
+// Dial connects to the admin socket at an AdminListen address, as
+// yggdrasilctl does.
+func Dial(ctx context.Context, listen string) (net.Conn, error) {
+	var dialer net.Dialer
+	u, err := url.Parse(listen)
+	if err != nil {
+		return dialer.DialContext(ctx, "tcp", listen)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "unix":
+		return dialer.DialContext(ctx, "unix", listen[7:])
+	case "tcp":
+		return dialer.DialContext(ctx, "tcp", u.Host)
+	default:
+		return dialer.DialContext(ctx, "tcp", listen)
+	}
+}
@@ -0,0 +1,103 @@
+package adminauth
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+func TestReadOnly(t *testing.T) {
+	tests := map[string]bool{
+		"getPeers":            true,
+		"GETSELF":             true,
+		"list":                true,
+		"subscribe":           true,
+		"getEvents":           true,
+		"meshnameResolve":     true,
+		"addPeer":             false,
+		"removePeer":          false,
+		"setLogLevel":         false,
+		"resetTraffic":        false,
+		"debug_remoteGetSelf": false,
+		"unknownCommand":      false,
+		"":                    false,
+	}
+	for command, want := range tests {
+		if got := ReadOnly(command); got != want {
+			t.Errorf("ReadOnly(%q) = %v, want %v", command, got, want)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tokens := []popura.AdminToken{
+		{Token: "admin-secret", Role: RoleAdmin},
+		{Token: "read-secret", Role: RoleRead},
+	}
+	tests := []struct {
+		name    string
+		tokens  []popura.AdminToken
+		command string
+		args    string
+		role    string
+		err     string
+	}{
+		{"no tokens, read", nil, "getPeers", `{}`, "", ""},
+		{"no tokens, write", nil, "addPeer", `{"uri":"tcp://[::1]:1"}`, "", ""},
+		{"no tokens, token given", nil, "addPeer", `{"token":"x"}`, "", ""},
+		{"missing, read", tokens, "getPeers", `{}`, "", ErrUnauthorized},
+		{"missing, write", tokens, "addPeer", `{"uri":"tcp://[::1]:1"}`, "", ErrUnauthorized},
+		{"no arguments", tokens, "getPeers", ``, "", ErrUnauthorized},
+		{"empty", tokens, "getPeers", `{"token":""}`, "", ErrUnauthorized},
+		{"wrong, read", tokens, "getPeers", `{"token":"guess"}`, "", ErrUnauthorized},
+		{"wrong, write", tokens, "addPeer", `{"token":"guess"}`, "", ErrUnauthorized},
+		{"read, read", tokens, "getPeers", `{"token":"read-secret"}`, RoleRead, ""},
+		{"read, write", tokens, "addPeer", `{"token":"read-secret","uri":"tcp://[::1]:1"}`, RoleRead, ErrForbidden},
+		{"admin, read", tokens, "getPeers", `{"token":"admin-secret"}`, RoleAdmin, ""},
+		{"admin, write", tokens, "addPeer", `{"token":"admin-secret","uri":"tcp://[::1]:1"}`, RoleAdmin, ""},
+		{"bad arguments", tokens, "getPeers", `"admin-secret"`, "", "Failed to unmarshal request"},
+	}
+	remote := &net.TCPAddr{IP: net.IPv6loopback, Port: 1}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Proxy{log: logging.New(io.Discard, "text").Logger("admin"), tokens: test.tokens}
+			var token string
+			var want map[string]json.RawMessage
+			if test.args != "" {
+				if json.Unmarshal([]byte(test.args), &want) == nil {
+					_ = json.Unmarshal(want["token"], &token)
+					delete(want, "token")
+				}
+			}
+			if test.tokens != nil {
+				if role := p.role(token); role != test.role {
+					t.Errorf("role(%q) = %q, want %q", token, role, test.role)
+				}
+			}
+			args, err := p.authorize(&request{Name: test.command, Arguments: json.RawMessage(test.args)}, remote)
+			switch {
+			case test.err != "":
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("got error %v", err)
+			}
+			var got map[string]json.RawMessage
+			if err := json.Unmarshal(args, &got); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := got["token"]; ok {
+				t.Errorf("token was forwarded in %s", args)
+			}
+			if len(got) != len(want) {
+				t.Errorf("got arguments %s, want %s", args, test.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/adminauth"
+	"github.com/popura-network/Popura/src/dashboard"
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+const (
+	apiPrefix       = "/api/"
+	openAPIPath     = "/api/openapi.json"
+	callTimeout     = 30 * time.Second
+	maxRequestBody  = 1 << 20
+	shutdownTimeout = 5 * time.Second
+)
+
+// APIServer serves the admin API over HTTP. Rather than keeping its own copy
+// of the admin handlers, it forwards each request to the admin socket, so it
+// offers exactly the commands registered there, mapped onto REST routes:
+// getPeers is GET /api/peers, addPeer is POST /api/peers and so on.
+type APIServer struct {
+	log         *logging.Logger
+	config      popura.APIConfig
+	adminListen string
+	server      *http.Server
+	addr        *net.TCPAddr // that the server listens on
+	lock        sync.RWMutex
+	routes      []route // cached once the admin socket has listed them
+	routeLock   sync.Mutex
+}
+
+func (s *APIServer) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
+	s.log = log
+	s.config = popConfig.API
+	s.adminListen = yggConfig.AdminListen
+	if s.config.Enable {
+		if _, _, err := net.SplitHostPort(s.config.Listen); err != nil {
+			return err
+		}
+		if s.adminListen == "" || s.adminListen == "none" {
+			return errors.New("the admin socket must be enabled with AdminListen")
+		}
+	}
+	return nil
+}
+
+func (s *APIServer) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.config.Enable {
+		return nil
+	}
+	if s.server != nil {
+		return errors.New("already started")
+	}
+	listener, err := net.Listen("tcp", s.config.Listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(openAPIPath, s.handleOpenAPI)
+	mux.HandleFunc(apiPrefix, s.handleAPI)
+	if s.config.Dashboard {
+		mux.Handle("/", dashboard.Handler())
+	}
+	s.server = &http.Server{Handler: s.checkOrigin(mux)}
+	s.addr = listener.Addr().(*net.TCPAddr)
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.log.Errorln("api: server failed:", err)
+		}
+	}(s.server)
+	s.log.Infoln("api: listening on", "http://"+listener.Addr().String()+apiPrefix)
+	if s.config.Dashboard {
+		s.log.Infoln("api: serving the dashboard on", "http://"+listener.Addr().String()+"/")
+	}
+	return nil
+}
+
+func (s *APIServer) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := s.server.Shutdown(ctx)
+	s.server = nil
+	return err
+}
+
+func (s *APIServer) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
+func (s *APIServer) SetupAdminHandlers(a *admin.AdminSocket)                                   {}
+
+func (s *APIServer) IsStarted() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.server != nil
+}
+
+func (s *APIServer) Health() error {
+	if s.config.Enable && !s.IsStarted() {
+		return errors.New("server is not running")
+	}
+	return nil
+}
+
+// adminError is an error returned by an admin handler, as opposed to a
+// failure to reach the admin socket.
+type adminError string
+
+func (e adminError) Error() string { return string(e) }
+
+// call sends a request to the admin socket and returns its response.
+func (s *APIServer) call(ctx context.Context, name string, args json.RawMessage) (json.RawMessage, error) {
+	conn, err := adminauth.Dial(ctx, s.adminListen)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(callTimeout))
+	if err := json.NewEncoder(conn).Encode(&admin.AdminSocketRequest{Name: name, Arguments: args}); err != nil {
+		return nil, err
+	}
+	var resp admin.AdminSocketResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != "success" {
+		return nil, adminError(resp.Error)
+	}
+	return resp.Response, nil
+}
+
+// routeTable returns the routes of the commands registered with the admin
+// socket. They don't change while the node is running, so they are only
+// listed once.
+func (s *APIServer) routeTable(ctx context.Context, token string) ([]route, error) {
+	s.routeLock.Lock()
+	defer s.routeLock.Unlock()
+	if s.routes == nil {
+		commands, err := s.commands(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		s.routes = routes(commands)
+	}
+	return s.routes, nil
+}
+
+// commands returns the commands registered with the admin socket.
+func (s *APIServer) commands(ctx context.Context, token string) ([]admin.ListEntry, error) {
+	var args json.RawMessage
+	if token != "" {
+		args, _ = json.Marshal(map[string]string{"token": token})
+	}
+	raw, err := s.call(ctx, "list", args)
+	if err != nil {
+		return nil, err
+	}
+	var list admin.ListResponse
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+	return list.List, nil
+}
+
+// bearerToken returns the token given in the Authorization header of r, which
+// is passed on to the admin socket when tokens are configured for it.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+// writeCallError writes the error of a call to the admin socket, with the
+// status matching its cause.
+func writeCallError(rw http.ResponseWriter, err error) {
+	if _, ok := err.(adminError); !ok {
+		writeError(rw, http.StatusBadGateway, err)
+		return
+	}
+	switch err.Error() {
+	case adminauth.ErrUnauthorized:
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(rw, http.StatusUnauthorized, err)
+	case adminauth.ErrForbidden:
+		writeError(rw, http.StatusForbidden, err)
+	default:
+		writeError(rw, http.StatusBadRequest, err)
+	}
+}
+
+// checkOrigin refuses requests that were sent to another host name than the
+// address the server listens on, or that come from a web page of another
+// origin, so that other web sites can't use the API through the browser of
+// its user, whether by DNS rebinding or by cross-site requests.
+func (s *APIServer) checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			writeError(rw, http.StatusForbidden, errors.New("requests must be sent to "+s.config.Listen))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme != "http" || u.Host != r.Host {
+				writeError(rw, http.StatusForbidden, errors.New("cross-origin requests are not allowed"))
+				return
+			}
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// allowedHost reports whether host, as given in the Host header, is the
+// address the server listens on. If that is an unspecified address, any IP
+// address is allowed, but no host name other than localhost.
+func (s *APIServer) allowedHost(host string) bool {
+	h, port, err := net.SplitHostPort(host)
+	if err != nil || port != strconv.Itoa(s.addr.Port) {
+		return false
+	}
+	ip := net.ParseIP(h)
+	switch {
+	case strings.EqualFold(h, "localhost"):
+		return s.addr.IP.IsLoopback() || s.addr.IP.IsUnspecified()
+	case ip == nil:
+		return false
+	case s.addr.IP.IsUnspecified():
+		return true
+	default:
+		return ip.Equal(s.addr.IP)
+	}
+}
+
+func (s *APIServer) handleAPI(rw http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	table, err := s.routeTable(r.Context(), token)
+	if err != nil {
+		writeCallError(rw, err)
+		return
+	}
+	var command string
+	pathFound := false
+	for _, route := range table {
+		if route.path == r.URL.Path {
+			pathFound = true
+			if route.method == r.Method {
+				command = route.command
+			}
+		}
+	}
+	switch {
+	case !pathFound:
+		writeError(rw, http.StatusNotFound, errors.New("no such endpoint, see "+openAPIPath))
+		return
+	case command == "":
+		writeError(rw, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	// Arguments are taken from the query string, and from a JSON object in
+	// the body for methods other than GET. Requiring the JSON content type
+	// for those also keeps web pages from sending them without the browser
+	// asking the server first.
+	args := make(map[string]interface{})
+	for key, values := range r.URL.Query() {
+		args[key] = values[len(values)-1]
+	}
+	if r.Method != http.MethodGet {
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			writeError(rw, http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/json"))
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody))
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		if len(strings.TrimSpace(string(body))) > 0 {
+			if err := json.Unmarshal(body, &args); err != nil {
+				writeError(rw, http.StatusBadRequest, errors.New("body must be a JSON object of arguments"))
+				return
+			}
+		}
+	}
+	delete(args, "token")
+	if token != "" {
+		args["token"] = token
+	}
+	in, err := json.Marshal(args)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	out, err := s.call(r.Context(), command, in)
+	if err != nil {
+		writeCallError(rw, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(out)
+}
+
+func (s *APIServer) handleOpenAPI(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(rw, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	commands, err := s.commands(r.Context(), bearerToken(r))
+	if err != nil {
+		writeCallError(rw, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(openAPI(commands)); err != nil {
+		s.log.Debugln("api: error writing response:", err)
+	}
+}
+
+func writeError(rw http.ResponseWriter, status int, err error) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		listen string
+		host   string
+		origin string
+		status int
+	}{
+		{"[::1]:9466", "[::1]:9466", "", http.StatusOK},
+		{"[::1]:9466", "localhost:9466", "", http.StatusOK},
+		{"[::1]:9466", "[::1]:9466", "http://[::1]:9466", http.StatusOK},
+		{"[::1]:9466", "evil.example:9466", "", http.StatusForbidden},
+		{"[::1]:9466", "[::1]:9467", "", http.StatusForbidden},
+		{"[::1]:9466", "[::1]", "", http.StatusForbidden},
+		{"[::1]:9466", "127.0.0.1:9466", "", http.StatusForbidden},
+		{"[::1]:9466", "[::1]:9466", "http://evil.example", http.StatusForbidden},
+		{"[::1]:9466", "[::1]:9466", "https://[::1]:9466", http.StatusForbidden},
+		{"[::1]:9466", "[::1]:9466", "null", http.StatusForbidden},
+		{"[::]:9466", "[200::1]:9466", "", http.StatusOK},
+		{"[::]:9466", "localhost:9466", "", http.StatusOK},
+		{"[::]:9466", "evil.example:9466", "", http.StatusForbidden},
+		{"192.0.2.1:9466", "localhost:9466", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		addr, err := net.ResolveTCPAddr("tcp", test.listen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &APIServer{addr: addr}
+		s.config.Listen = test.listen
+		r := httptest.NewRequest(http.MethodGet, "/api/self", nil)
+		r.Host = test.host
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		rw := httptest.NewRecorder()
+		s.checkOrigin(ok).ServeHTTP(rw, r)
+		if rw.Code != test.status {
+			t.Errorf("listening on %s, Host %s and Origin %q: got status %d, want %d", test.listen, test.host, test.origin, rw.Code, test.status)
+		}
+	}
+}
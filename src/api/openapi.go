@@ -0,0 +1,161 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+	"github.com/yggdrasil-network/yggdrasil-go/src/version"
+)
+
+// route maps an HTTP method and path onto an admin command.
+type route struct {
+	method  string
+	path    string
+	command string
+	entry   admin.ListEntry
+}
+
+// verbs map the prefixes of admin commands onto HTTP methods.
+var verbs = []struct {
+	prefix string
+	method string
+}{
+	{"get", http.MethodGet},
+	{"add", http.MethodPost},
+	{"remove", http.MethodDelete},
+	{"set", http.MethodPut},
+}
+
+// routes returns the route of each command. The admin socket reports command
+// names in lower case, so the path is whatever follows a verb such as "get",
+// with commands that add to, remove from or set a collection taking its path
+// as well: getPeers is GET /api/peers, addPeer is POST /api/peers. Any other
+// command is posted to its own name, e.g. POST /api/meshnameresolve.
+func routes(commands []admin.ListEntry) []route {
+	gets := make(map[string]bool)
+	for _, c := range commands {
+		if strings.HasPrefix(c.Command, "get") && len(c.Command) > len("get") {
+			gets[c.Command[len("get"):]] = true
+		}
+	}
+	routes := make([]route, 0, len(commands))
+	for _, c := range commands {
+		r := route{
+			method:  http.MethodPost,
+			path:    apiPrefix + c.Command,
+			command: c.Command,
+			entry:   c,
+		}
+		for _, verb := range verbs {
+			noun := strings.TrimPrefix(c.Command, verb.prefix)
+			if noun == c.Command || noun == "" {
+				continue
+			}
+			if verb.method != http.MethodGet && gets[noun+"s"] {
+				noun += "s"
+			}
+			r.method = verb.method
+			r.path = apiPrefix + noun
+			break
+		}
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].path != routes[j].path {
+			return routes[i].path < routes[j].path
+		}
+		return routes[i].method < routes[j].method
+	})
+	return routes
+}
+
+// openAPI returns an OpenAPI 3 document describing the routes of commands.
+// Arguments given in square brackets by the admin socket are optional.
+func openAPI(commands []admin.ListEntry) map[string]interface{} {
+	errorResponse := map[string]interface{}{
+		"description": "The command failed",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+			},
+		},
+	}
+	paths := make(map[string]interface{})
+	for _, r := range routes(commands) {
+		properties := make(map[string]interface{})
+		required := []string{}
+		parameters := []interface{}{}
+		for _, field := range r.entry.Fields {
+			name := strings.Trim(field, "[]")
+			optional := name != field
+			if !optional {
+				required = append(required, name)
+			}
+			properties[name] = map[string]interface{}{"type": "string"}
+			parameters = append(parameters, map[string]interface{}{
+				"name":     name,
+				"in":       "query",
+				"required": !optional,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		operation := map[string]interface{}{
+			"operationId": r.command,
+			"summary":     r.entry.Description,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The response of the " + r.command + " admin command",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{"type": "object"},
+						},
+					},
+				},
+				"400": errorResponse,
+				"502": errorResponse,
+			},
+		}
+		if r.method == http.MethodGet || r.method == http.MethodDelete {
+			operation["parameters"] = parameters
+		} else if len(properties) > 0 {
+			schema := map[string]interface{}{
+				"type":       "object",
+				"properties": properties,
+			}
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+			operation["requestBody"] = map[string]interface{}{
+				"required": len(required) > 0,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schema},
+				},
+			}
+		}
+		item, ok := paths[r.path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[r.path] = item
+		}
+		item[strings.ToLower(r.method)] = operation
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       version.BuildName() + " admin API",
+			"version":     version.BuildVersion(),
+			"description": "The commands of the admin socket, served over HTTP.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+				},
+			},
+		},
+	}
+}
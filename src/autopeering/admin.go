@@ -0,0 +1,50 @@
+package autopeering
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+type GetAutopeeringRequest struct{}
+type GetAutopeeringResponse struct {
+	Enabled     bool     `json:"enabled"`
+	Peer        string   `json:"peer,omitempty"`
+	Candidates  []string `json:"candidates"`
+	PublicPeers int      `json:"public_peers"`
+	Attempts    uint64   `json:"attempts"`
+	Failures    uint64   `json:"failures"`
+}
+
+func (ap *AutoPeering) getAutopeeringHandler(req *GetAutopeeringRequest, res *GetAutopeeringResponse) error {
+	ap.lock.Lock()
+	defer ap.lock.Unlock()
+	res.Enabled = ap.enabled
+	res.Peer = ap.added
+	res.Candidates = make([]string, 0, len(ap.candidates))
+	for _, u := range ap.candidates {
+		res.Candidates = append(res.Candidates, u.String())
+	}
+	res.PublicPeers = len(ap.peers)
+	res.Attempts = atomic.LoadUint64(&ap.attempts)
+	res.Failures = atomic.LoadUint64(&ap.failures)
+	return nil
+}
+
+func (ap *AutoPeering) SetupAdminHandlers(a *admin.AdminSocket) {
+	_ = a.AddHandler(
+		"getAutopeering", "Show the peer added by autopeering and the closest public peers found last", []string{},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &GetAutopeeringRequest{}
+			res := &GetAutopeeringResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := ap.getAutopeeringHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+}
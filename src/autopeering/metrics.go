@@ -0,0 +1,22 @@
+package autopeering
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/popura-network/Popura/src/metrics"
+)
+
+func (ap *AutoPeering) WriteMetrics(w *metrics.Writer) {
+	w.Bool("popura_autopeering_enabled", "Whether autopeering is enabled.", ap.enabled)
+	w.Gauge("popura_autopeering_public_peers", "Number of known public peers to pick from.", float64(len(ap.peers)))
+	remote := 0
+	for _, p := range ap.lister.GetPeers() {
+		if !strings.HasPrefix(p.Remote, linkLocalPrefix) {
+			remote++
+		}
+	}
+	w.Gauge("popura_autopeering_remote_peers", "Number of connected peers that aren't link-local.", float64(remote))
+	w.Counter("popura_autopeering_attempts_total", "Connections to public peers attempted.", float64(atomic.LoadUint64(&ap.attempts)))
+	w.Counter("popura_autopeering_failures_total", "Connections to public peers that failed.", float64(atomic.LoadUint64(&ap.failures)))
+}
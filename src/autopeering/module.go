@@ -3,14 +3,15 @@ package autopeering
 import (
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gologme/log"
-
-	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
 	"github.com/yggdrasil-network/yggdrasil-go/src/config"
 	"github.com/yggdrasil-network/yggdrasil-go/src/core"
 
+	"github.com/popura-network/Popura/src/events"
+	"github.com/popura-network/Popura/src/logging"
 	"github.com/popura-network/Popura/src/popura"
 )
 
@@ -21,17 +22,41 @@ const (
 )
 
 type AutoPeering struct {
-	core           *core.Core
-	log            *log.Logger
-	checkPeerTimer *time.Timer
-	hadPeers       time.Time
-	peers          []url.URL
-	enabled        bool
+	attempts   uint64 // atomic, must stay 64-bit aligned
+	failures   uint64 // atomic, must stay 64-bit aligned
+	core       *core.Core
+	log        *logging.Logger
+	bus        *events.Bus
+	lister     popura.PeerLister
+	relay      popura.PeerRelay
+	stop       chan struct{} // closed by Stop
+	done       chan struct{} // closed once checkPeerLoop returns
+	hadPeers   time.Time
+	peers      []url.URL
+	enabled    bool
+	added      string    // URI of the last peer added, guarded by lock
+	candidates []url.URL // closest peers found last, guarded by lock
+	lock       sync.Mutex
+}
+
+// Options are the options of AutoPeering.
+type Options struct {
+	Events *events.Bus       // to publish events on
+	Peers  popura.PeerLister // the core if nil
+	Relay  popura.PeerRelay  // to connect to peers through, if not nil
 }
 
-func (ap *AutoPeering) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *log.Logger, options interface{}) error {
+// Init takes Options as its options.
+func (ap *AutoPeering) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
 	ap.core = yggcore
 	ap.log = log
+	opts, _ := options.(Options)
+	ap.bus = opts.Events
+	ap.lister = opts.Peers
+	if ap.lister == nil {
+		ap.lister = yggcore
+	}
+	ap.relay = opts.Relay
 	ap.peers = GetPublicPeers()
 	ap.enabled = popConfig.Autopeering.Enable
 	return nil
@@ -39,23 +64,43 @@ func (ap *AutoPeering) Init(yggcore *core.Core, yggConfig *config.NodeConfig, po
 
 func (ap *AutoPeering) Start() error {
 	if ap.enabled {
+		ap.stop = make(chan struct{})
+		ap.done = make(chan struct{})
 		go ap.checkPeerLoop()
 	}
 	ap.log.Infoln("autopeering: module started")
 	return nil
 }
 
+// Stop stops checking the peers, and waits for a check that is running to
+// finish.
 func (ap *AutoPeering) Stop() error {
-	if ap.checkPeerTimer != nil {
-		ap.checkPeerTimer.Stop()
+	if ap.stop != nil {
+		close(ap.stop)
+		<-ap.done
+		ap.stop = nil
 	}
 	return nil
 }
 
 func (ap *AutoPeering) checkPeerLoop() {
+	defer close(ap.done)
+	for {
+		ap.checkPeers()
+		select {
+		case <-ap.stop:
+			return
+		case <-time.After(peerCheckTimeout):
+		}
+	}
+}
+
+// checkPeers adds a public peer if there has been no connected peer other
+// than link-local ones for autopeerTimeout.
+func (ap *AutoPeering) checkPeers() {
 	havePeers := false
 
-	for _, p := range ap.core.GetPeers() {
+	for _, p := range ap.lister.GetPeers() {
 		if !strings.HasPrefix(p.Remote, linkLocalPrefix) {
 			ap.log.Debugln("autopeering: remote peer is connected ", p.Remote)
 			havePeers = true
@@ -68,24 +113,66 @@ func (ap *AutoPeering) checkPeerLoop() {
 	} else if time.Since(ap.hadPeers) > autopeerTimeout {
 		ap.log.Debugln("autopeering: adding a new peer")
 		ap.hadPeers = time.Now()
-		peers := RandomPick(GetClosestPeers(ap.peers, 10), 1)
+		ap.lock.Lock()
+		lost := ap.added
+		ap.lock.Unlock()
+		if lost != "" {
+			ap.removed(lost, "disconnected")
+		}
+		closest := GetClosestPeers(ap.peers, 10)
+		ap.lock.Lock()
+		ap.candidates = closest
+		ap.lock.Unlock()
+		peers := RandomPick(closest, 1)
 		if len(peers) == 1 {
 			peerUri := peers[0]
 
 			ap.log.Infoln("autopeering: adding new peer", peerUri.String())
+			atomic.AddUint64(&ap.attempts, 1)
+			ap.lock.Lock()
+			ap.added = peerUri.String()
+			ap.lock.Unlock()
+			ap.bus.Publish(events.AutopeerAdded, map[string]string{"uri": peerUri.String()})
 			go func() {
-				if err := ap.core.CallPeer(&peerUri, ""); err != nil {
+				if err := ap.call(peerUri); err != nil {
+					atomic.AddUint64(&ap.failures, 1)
 					ap.log.Infoln("autopeering: peer connection failed:", err)
+					ap.removed(peerUri.String(), err.Error())
 				}
 			}()
 		}
 	}
+}
+
+// call connects to the peer u, through the relay if there is one.
+func (ap *AutoPeering) call(u url.URL) error {
+	if ap.relay != nil {
+		relay, err := ap.relay.RelayPeer(u.String())
+		if err != nil {
+			return err
+		}
+		r, err := url.Parse(relay)
+		if err != nil {
+			return err
+		}
+		u = *r
+	}
+	return ap.core.CallPeer(&u, "")
+}
 
-	ap.checkPeerTimer = time.AfterFunc(peerCheckTimeout, func() {
-		ap.checkPeerLoop()
-	})
+// removed publishes that the peer added last, uri, is gone, unless that was
+// already published.
+func (ap *AutoPeering) removed(uri, reason string) {
+	ap.lock.Lock()
+	if ap.added != uri {
+		ap.lock.Unlock()
+		return
+	}
+	ap.added = ""
+	ap.lock.Unlock()
+	ap.bus.Publish(events.AutopeerRemoved, map[string]string{"uri": uri, "reason": reason})
 }
 
 func (ap *AutoPeering) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
-func (ap *AutoPeering) SetupAdminHandlers(a *admin.AdminSocket)                                   {}
 func (ap *AutoPeering) IsStarted() bool                                                           { return false }
+func (ap *AutoPeering) Health() error                                                             { return nil }
@@ -0,0 +1,22 @@
+// Package dashboard holds a web dashboard for the node. It is a static page
+// that calls the HTTP API, so it shows and does exactly what the admin socket
+// offers, as yggdrasilctl does.
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
@@ -0,0 +1,296 @@
+"use strict";
+
+// The dashboard only calls the HTTP API, which forwards every request to the
+// admin socket, so it shows and does exactly what yggdrasilctl can.
+
+const refreshInterval = 2000; // milliseconds
+const historyLength = 60; // samples of traffic kept per peer
+
+let token = sessionStorage.getItem("token") || "";
+const traffic = new Map(); // peer key -> {rx, tx, time, rates: [{rx, tx}]}
+
+class APIError extends Error {
+  constructor(status, message) {
+    super(message);
+    this.status = status;
+  }
+}
+
+async function api(method, path, body) {
+  const headers = {};
+  if (token) {
+    headers["Authorization"] = "Bearer " + token;
+  }
+  const options = { method, headers };
+  if (method !== "GET") {
+    headers["Content-Type"] = "application/json";
+  }
+  if (body !== undefined) {
+    options.body = JSON.stringify(body);
+  }
+  const resp = await fetch("api/" + path, options);
+  const data = await resp.json().catch(() => ({}));
+  if (resp.status === 401) {
+    document.getElementById("login").hidden = false;
+  }
+  if (!resp.ok) {
+    throw new APIError(resp.status, data.error || resp.statusText);
+  }
+  return data;
+}
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    node.setAttribute(key, value);
+  }
+  node.append(...children);
+  return node;
+}
+
+function bytes(n) {
+  const units = ["B", "kB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+function duration(seconds) {
+  seconds = Math.floor(seconds);
+  const d = Math.floor(seconds / 86400);
+  const h = Math.floor(seconds / 3600) % 24;
+  const m = Math.floor(seconds / 60) % 60;
+  const s = seconds % 60;
+  if (d > 0) return `${d}d ${h}h`;
+  if (h > 0) return `${h}h ${m}m`;
+  if (m > 0) return `${m}m ${s}s`;
+  return `${s}s`;
+}
+
+function setStatus(text, error) {
+  const status = document.getElementById("status");
+  status.textContent = text;
+  status.className = error ? "error" : "muted";
+}
+
+function fillList(section, entries) {
+  const dl = document.querySelector(`#${section} dl`);
+  dl.replaceChildren();
+  for (const [name, value] of entries) {
+    dl.append(el("dt", {}, name), el("dd", {}, String(value)));
+  }
+}
+
+function fillTable(section, rows) {
+  const tbody = document.querySelector(`#${section} tbody`);
+  tbody.replaceChildren(...rows);
+  if (rows.length === 0) {
+    const columns = document.querySelectorAll(`#${section} th`).length;
+    tbody.append(el("tr", {}, el("td", { colspan: columns, class: "muted" }, "None")));
+  }
+}
+
+function unavailable(section, err) {
+  fillList(section, [["Not available", err.message]]);
+}
+
+// sparkline draws the received and sent rates of a peer over time.
+function sparkline(rates) {
+  const width = 120, height = 24;
+  const max = Math.max(1, ...rates.map((r) => Math.max(r.rx, r.tx)));
+  const svg = document.createElementNS("http://www.w3.org/2000/svg", "svg");
+  svg.setAttribute("class", "traffic");
+  svg.setAttribute("width", width);
+  svg.setAttribute("height", height);
+  for (const dir of ["rx", "tx"]) {
+    const points = rates.map((r, i) => {
+      const x = width - (rates.length - 1 - i) * (width / (historyLength - 1));
+      const y = height - 1 - (r[dir] / max) * (height - 2);
+      return `${x.toFixed(1)},${y.toFixed(1)}`;
+    });
+    const line = document.createElementNS("http://www.w3.org/2000/svg", "polyline");
+    line.setAttribute("class", dir);
+    line.setAttribute("points", points.join(" "));
+    svg.append(line);
+  }
+  const title = document.createElementNS("http://www.w3.org/2000/svg", "title");
+  const last = rates[rates.length - 1] || { rx: 0, tx: 0 };
+  title.textContent = `${bytes(last.rx)}/s received, ${bytes(last.tx)}/s sent`;
+  svg.append(title);
+  return svg;
+}
+
+// sample records the byte counters of a peer and returns its rates so far.
+function sample(peer, now) {
+  let t = traffic.get(peer.key);
+  if (!t || peer.bytes_recvd < t.rx || peer.bytes_sent < t.tx) {
+    t = { rx: peer.bytes_recvd, tx: peer.bytes_sent, time: now, rates: [] };
+    traffic.set(peer.key, t);
+    return t.rates;
+  }
+  const seconds = (now - t.time) / 1000;
+  if (seconds > 0) {
+    t.rates.push({
+      rx: (peer.bytes_recvd - t.rx) / seconds,
+      tx: (peer.bytes_sent - t.tx) / seconds,
+    });
+    if (t.rates.length > historyLength) {
+      t.rates.shift();
+    }
+  }
+  t.rx = peer.bytes_recvd;
+  t.tx = peer.bytes_sent;
+  t.time = now;
+  return t.rates;
+}
+
+async function removePeer(uri) {
+  if (!confirm(`Remove the peer ${uri}?`)) {
+    return;
+  }
+  try {
+    await api("DELETE", "peers?uri=" + encodeURIComponent(uri));
+    refresh();
+  } catch (err) {
+    alert("Failed to remove the peer: " + err.message);
+  }
+}
+
+function showPeers(data) {
+  const now = Date.now();
+  const seen = new Set();
+  const peers = (data.peers || []).slice().sort((a, b) => a.port - b.port);
+  fillTable("peers", peers.map((p) => {
+    seen.add(p.key);
+    const remove = el("button", { type: "button" }, "Remove");
+    remove.addEventListener("click", () => removePeer(p.remote));
+    return el("tr", {},
+      el("td", { class: "mono", title: p.key }, p.address),
+      el("td", { class: "mono" }, p.remote),
+      el("td", {}, duration(p.uptime)),
+      el("td", { class: "rx" }, bytes(p.bytes_recvd)),
+      el("td", { class: "tx" }, bytes(p.bytes_sent)),
+      el("td", {}, sparkline(sample(p, now))),
+      el("td", {}, remove));
+  }));
+  for (const key of traffic.keys()) {
+    if (!seen.has(key)) {
+      traffic.delete(key);
+    }
+  }
+  return peers.length;
+}
+
+function showSessions(data) {
+  const sessions = (data.sessions || []).slice().sort((a, b) => a.address.localeCompare(b.address));
+  fillTable("sessions", sessions.map((s) => el("tr", {},
+    el("td", { class: "mono", title: s.key }, s.address),
+    el("td", {}, duration(s.uptime)),
+    el("td", { class: "rx" }, bytes(s.bytes_recvd)),
+    el("td", { class: "tx" }, bytes(s.bytes_sent)))));
+}
+
+function showDHT(data) {
+  const dht = (data.dht || []).slice().sort((a, b) => a.address.localeCompare(b.address));
+  fillTable("dht", dht.map((d) => el("tr", {},
+    el("td", { class: "mono", title: d.key }, d.address),
+    el("td", {}, d.port),
+    el("td", {}, d.rest))));
+}
+
+function showAutopeering(data) {
+  fillList("autopeering", [
+    ["Enabled", data.enabled ? "yes" : "no"],
+    ["Peer", data.peer || "none"],
+    ["Attempts", `${data.attempts} (${data.failures} failed)`],
+    ["Public peers", data.public_peers],
+    ["Closest found", (data.candidates || []).join(", ") || "none yet"],
+  ]);
+}
+
+function showMeshname(data) {
+  const entries = [
+    ["Enabled", data.enabled ? "yes" : "no"],
+    ["Running", data.started ? "yes" : "no"],
+  ];
+  for (const [name, key] of [["DNS", "listen"], ["DNS-over-TLS", "tls_listen"],
+    ["DNS-over-HTTPS", "https_listen"], ["Zone", "zone_listen"]]) {
+    if (data[key]) {
+      entries.push([name, data[key]]);
+    }
+  }
+  entries.push(["Queries", `${data.queries} (${data.errors} errors, ${data.rate_limited} rate limited)`]);
+  if (data.zone && data.zone.length > 0) {
+    entries.push(["Records", data.zone.join("\n")]);
+  }
+  fillList("meshname", entries);
+}
+
+async function refresh() {
+  const [self, peers, sessions, dht, autopeering, meshname] = await Promise.allSettled([
+    api("GET", "self"),
+    api("GET", "peers"),
+    api("GET", "sessions"),
+    api("GET", "dht"),
+    api("GET", "autopeering"),
+    api("GET", "meshname"),
+  ]);
+  if (self.status === "rejected") {
+    setStatus(self.reason.message, true);
+    return;
+  }
+  document.getElementById("login").hidden = true;
+  fillList("self", [
+    ["Address", self.value.address],
+    ["Subnet", self.value.subnet],
+    ["Public key", self.value.key],
+    ["Coordinates", "[" + (self.value.coords || []).join(" ") + "]"],
+    ["Version", `${self.value.build_name} ${self.value.build_version}`],
+  ]);
+  let count = 0;
+  if (peers.status === "fulfilled") {
+    count = showPeers(peers.value);
+  }
+  if (sessions.status === "fulfilled") {
+    showSessions(sessions.value);
+  }
+  if (dht.status === "fulfilled") {
+    showDHT(dht.value);
+  }
+  if (autopeering.status === "fulfilled") {
+    showAutopeering(autopeering.value);
+  } else {
+    unavailable("autopeering", autopeering.reason);
+  }
+  if (meshname.status === "fulfilled") {
+    showMeshname(meshname.value);
+  } else {
+    unavailable("meshname", meshname.reason);
+  }
+  setStatus(`${count} peers, updated ${new Date().toLocaleTimeString()}`, false);
+}
+
+document.getElementById("login").addEventListener("submit", (e) => {
+  e.preventDefault();
+  token = document.getElementById("token").value;
+  sessionStorage.setItem("token", token);
+  refresh();
+});
+
+document.getElementById("add-peer").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const form = e.target;
+  try {
+    await api("POST", "peers", { uri: form.uri.value });
+    form.reset();
+    refresh();
+  } catch (err) {
+    alert("Failed to add the peer: " + err.message);
+  }
+});
+
+refresh();
+setInterval(refresh, refreshInterval);
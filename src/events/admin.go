@@ -0,0 +1,243 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+type SubscribeRequest struct {
+	Events string `json:"events"`
+}
+type SubscribeResponse struct {
+	Events       []string `json:"events"`
+	Subscription uint64   `json:"subscription"`
+}
+
+type GetEventsRequest struct {
+	Subscription uint64 `json:"subscription"`
+	Wait         int    `json:"wait"` // seconds
+}
+type GetEventsResponse struct {
+	Events []Event `json:"events"`
+}
+
+type UnsubscribeRequest struct {
+	Subscription uint64 `json:"subscription"`
+}
+type UnsubscribeResponse struct{}
+
+const (
+	// subscriptionBuffer is the number of events that may wait for the next
+	// getEvents of a subscription before further events are dropped.
+	subscriptionBuffer = 256
+	// subscriptionIdle is how long a subscription is kept without getEvents.
+	subscriptionIdle = time.Minute
+	// maxWait bounds how long getEvents waits for the first event.
+	maxWait = 30 * time.Second
+)
+
+// subscription holds the events published since a subscribe request, until
+// they are fetched with getEvents.
+type subscription struct {
+	events      <-chan Event
+	unsubscribe func()
+	types       map[string]bool
+	used        time.Time
+	polling     int // number of getEvents requests waiting
+}
+
+// parseTypes returns the types of events in a list of categories and types
+// such as "peers,autopeer_added", or all types if the list is empty.
+func parseTypes(list string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		switch {
+		case item == "":
+		case Categories[item] != nil:
+			for _, typ := range Categories[item] {
+				seen[typ] = true
+			}
+		case IsType(item):
+			seen[item] = true
+		default:
+			return nil, fmt.Errorf("unknown events %q", item)
+		}
+	}
+	var types []string
+	for _, typ := range Types {
+		if seen[typ] || len(seen) == 0 {
+			types = append(types, typ)
+		}
+	}
+	return types, nil
+}
+
+// subscribeHandler subscribes to the events asked for. The admin socket can
+// only answer each request once, so the events are then fetched with
+// getEvents on the same or another connection.
+func (m *Monitor) subscribeHandler(req *SubscribeRequest, res *SubscribeResponse) error {
+	types, err := parseTypes(req.Events)
+	if err != nil {
+		return err
+	}
+	if m.bus == nil {
+		return errors.New("events are not available")
+	}
+	sub := &subscription{types: make(map[string]bool), used: time.Now()}
+	for _, typ := range types {
+		sub.types[typ] = true
+	}
+	sub.events, sub.unsubscribe = m.bus.Subscribe(subscriptionBuffer)
+	m.subLock.Lock()
+	defer m.subLock.Unlock()
+	m.purgeSubscriptions()
+	if m.subs == nil {
+		m.subs = make(map[uint64]*subscription)
+	}
+	m.lastSub++
+	m.subs[m.lastSub] = sub
+	res.Events = types
+	res.Subscription = m.lastSub
+	return nil
+}
+
+// getEventsHandler returns the events of a subscription published since the
+// last getEvents, waiting up to req.Wait seconds for the first of them.
+func (m *Monitor) getEventsHandler(req *GetEventsRequest, res *GetEventsResponse) error {
+	m.subLock.Lock()
+	sub, ok := m.subs[req.Subscription]
+	if ok {
+		sub.polling++
+	}
+	m.subLock.Unlock()
+	if !ok {
+		return errors.New("unknown subscription")
+	}
+	defer func() {
+		m.subLock.Lock()
+		sub.polling--
+		sub.used = time.Now()
+		m.subLock.Unlock()
+	}()
+	wait := time.Duration(req.Wait) * time.Second
+	if wait > maxWait {
+		wait = maxWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	res.Events = []Event{}
+	for len(res.Events) < subscriptionBuffer {
+		var e Event
+		var ok bool
+		select {
+		case e, ok = <-sub.events:
+		default:
+			if len(res.Events) > 0 {
+				return nil
+			}
+			select {
+			case e, ok = <-sub.events:
+			case <-timer.C:
+				return nil
+			}
+		}
+		if !ok {
+			return errors.New("subscription has ended")
+		}
+		if sub.types[e.Type] {
+			res.Events = append(res.Events, e)
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) unsubscribeHandler(req *UnsubscribeRequest, res *UnsubscribeResponse) error {
+	m.subLock.Lock()
+	defer m.subLock.Unlock()
+	sub, ok := m.subs[req.Subscription]
+	if !ok {
+		return errors.New("unknown subscription")
+	}
+	sub.unsubscribe()
+	delete(m.subs, req.Subscription)
+	return nil
+}
+
+// purgeSubscriptions ends the subscriptions that haven't been used for
+// subscriptionIdle, whose clients have most likely gone away. It is called
+// with subLock held.
+func (m *Monitor) purgeSubscriptions() {
+	for id, sub := range m.subs {
+		if sub.polling == 0 && time.Since(sub.used) > subscriptionIdle {
+			sub.unsubscribe()
+			delete(m.subs, id)
+		}
+	}
+}
+
+// endSubscriptions ends every subscription, when the monitor is stopped.
+func (m *Monitor) endSubscriptions() {
+	m.subLock.Lock()
+	defer m.subLock.Unlock()
+	for id, sub := range m.subs {
+		sub.unsubscribe()
+		delete(m.subs, id)
+	}
+}
+
+func (m *Monitor) SetupAdminHandlers(a *admin.AdminSocket) {
+	categories := make([]string, 0, len(Categories))
+	for category := range Categories {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	_ = a.AddHandler(
+		"subscribe", "Subscribe to events ("+strings.Join(categories, ", ")+" or single types), to fetch them with getEvents", []string{"[events]"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &SubscribeRequest{}
+			res := &SubscribeResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := m.subscribeHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"getEvents", "Return the events of a subscription since the last call, waiting up to [wait] seconds for one", []string{"subscription", "[wait]"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &GetEventsRequest{}
+			res := &GetEventsResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := m.getEventsHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"unsubscribe", "End a subscription to events", []string{"subscription"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &UnsubscribeRequest{}
+			res := &UnsubscribeResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := m.unsubscribeHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+}
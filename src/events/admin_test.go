@@ -0,0 +1,35 @@
+package events
+
+import "testing"
+
+func TestSubscription(t *testing.T) {
+	m := &Monitor{bus: NewBus()}
+	sub := &SubscribeResponse{}
+	if err := m.subscribeHandler(&SubscribeRequest{Events: "peers"}, sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.subscribeHandler(&SubscribeRequest{Events: "unknown"}, &SubscribeResponse{}); err == nil {
+		t.Error("subscribed to unknown events")
+	}
+
+	m.bus.Publish(PeerConnected, map[string]string{"uri": "tcp://[::1]:1"})
+	m.bus.Publish(SessionOpened, nil)
+	m.bus.Publish(PeerDisconnected, nil)
+	res := &GetEventsResponse{}
+	if err := m.getEventsHandler(&GetEventsRequest{Subscription: sub.Subscription}, res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events) != 2 || res.Events[0].Type != PeerConnected || res.Events[1].Type != PeerDisconnected {
+		t.Errorf("got events %+v", res.Events)
+	}
+	if err := m.getEventsHandler(&GetEventsRequest{Subscription: sub.Subscription}, res); err != nil || len(res.Events) != 0 {
+		t.Errorf("got events %+v and error %v without waiting", res.Events, err)
+	}
+
+	if err := m.unsubscribeHandler(&UnsubscribeRequest{Subscription: sub.Subscription}, &UnsubscribeResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.getEventsHandler(&GetEventsRequest{Subscription: sub.Subscription}, res); err == nil {
+		t.Error("got events of an ended subscription")
+	}
+}
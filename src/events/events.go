@@ -0,0 +1,108 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of events published by the node.
+const (
+	PeerConnected          = "peer_connected"
+	PeerDisconnected       = "peer_disconnected"
+	SessionOpened          = "session_opened"
+	SessionClosed          = "session_closed"
+	AutopeerAdded          = "autopeer_added"
+	AutopeerRemoved        = "autopeer_removed"
+	MeshnameListenerFailed = "meshname_listener_failed"
+)
+
+// Types are all types of events, in the order they are documented.
+var Types = []string{PeerConnected, PeerDisconnected, SessionOpened, SessionClosed, AutopeerAdded, AutopeerRemoved, MeshnameListenerFailed}
+
+// Categories group the types of events, so that they can be subscribed to
+// together.
+var Categories = map[string][]string{
+	"peers":       {PeerConnected, PeerDisconnected},
+	"sessions":    {SessionOpened, SessionClosed},
+	"autopeering": {AutopeerAdded, AutopeerRemoved},
+	"meshname":    {MeshnameListenerFailed},
+}
+
+// IsType reports whether typ is one of Types.
+func IsType(typ string) bool {
+	for _, t := range Types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is something that happened to the node. Data holds its details, such
+// as the URI and key of a peer.
+type Event struct {
+	Type string            `json:"type"`
+	Time time.Time         `json:"time"`
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// Environ returns the event as environment variables: POPURA_EVENT with its
+// type, POPURA_EVENT_TIME and POPURA_EVENT_<KEY> for each item of its data.
+func (e Event) Environ() []string {
+	env := []string{
+		"POPURA_EVENT=" + e.Type,
+		"POPURA_EVENT_TIME=" + e.Time.UTC().Format(time.RFC3339),
+	}
+	for key, value := range e.Data {
+		env = append(env, "POPURA_EVENT_"+strings.ToUpper(key)+"="+value)
+	}
+	return env
+}
+
+// Bus passes events from the parts of the node that publish them to those
+// that subscribe to them. A nil Bus drops all events.
+type Bus struct {
+	lock        sync.RWMutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Publish sends an event of the given type to every subscriber. It never
+// blocks: subscribers that have fallen behind miss the event.
+func (b *Bus) Publish(typ string, data map[string]string) {
+	if b == nil {
+		return
+	}
+	e := Event{Type: typ, Time: time.Now(), Data: data}
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for c := range b.subscribers {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that receives every event published from now
+// on, holding up to buffer of them that haven't been received yet. The
+// returned function unsubscribes and closes the channel.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	c := make(chan Event, buffer)
+	b.lock.Lock()
+	b.subscribers[c] = struct{}{}
+	b.lock.Unlock()
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subscribers, c)
+			b.lock.Unlock()
+			close(c)
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package events
+
+// hookEnv lists the environment variables of the node that are passed on to
+// hook commands. Others, such as popura.PrivateKeyEnv or the socket of the
+// service manager, are kept from them.
+var hookEnv = []string{"PATH", "HOME", "LANG"}
+
+func sameEnvName(a, b string) bool {
+	return a == b
+}
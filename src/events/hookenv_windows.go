@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package events
+
+import "strings"
+
+// hookEnv lists the environment variables of the node that are passed on to
+// hook commands. Others, such as popura.PrivateKeyEnv, are kept from them.
+// Windows programs need the location of the system, the shell and temporary
+// files, and PATHEXT to find commands without their extension.
+var hookEnv = []string{
+	"PATH", "HOME", "LANG", "PATHEXT", "SystemRoot", "SystemDrive", "windir", "ComSpec",
+	"TEMP", "TMP", "USERPROFILE", "APPDATA", "LOCALAPPDATA", "ProgramData",
+}
+
+// Environment variables are case-insensitive on Windows, where PATH is
+// usually called Path.
+func sameEnvName(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
@@ -0,0 +1,185 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+// hookQueue is the number of events that may wait for a hook command to
+// become free before further events are dropped.
+const hookQueue = 64
+
+// HookRunner runs the commands configured in Popura.Hooks when events are
+// published, passing the details of the event in environment variables. Up to
+// MaxRunning events are handled at once, but events about the same peer or
+// session are always handled in the order they were published.
+type HookRunner struct {
+	log         *logging.Logger
+	bus         *Bus
+	config      popura.HooksConfig
+	cancel      context.CancelFunc
+	unsubscribe func()
+	wg          sync.WaitGroup
+	lock        sync.Mutex
+}
+
+// Init takes the Bus to subscribe to as its options.
+func (h *HookRunner) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
+	h.log = log
+	h.bus, _ = options.(*Bus)
+	h.config = popConfig.Hooks
+	for typ := range h.config.Commands {
+		if typ != "*" && !IsType(typ) {
+			return errors.New("unknown event type " + typ)
+		}
+	}
+	if h.config.MaxRunning < 1 {
+		return errors.New("MaxRunning must be at least 1")
+	}
+	if h.config.Timeout < 1 {
+		return errors.New("Timeout must be at least 1")
+	}
+	return nil
+}
+
+func (h *HookRunner) Start() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.config.Commands) == 0 || h.bus == nil {
+		return nil
+	}
+	if h.cancel != nil {
+		return errors.New("already started")
+	}
+	var ctx context.Context
+	ctx, h.cancel = context.WithCancel(context.Background())
+	var events <-chan Event
+	events, h.unsubscribe = h.bus.Subscribe(hookQueue)
+	// Each worker takes the events of its own share of subjects.
+	queues := make([]chan Event, h.config.MaxRunning)
+	for i := range queues {
+		queues[i] = make(chan Event, hookQueue)
+		h.wg.Add(1)
+		go func(queue <-chan Event) {
+			defer h.wg.Done()
+			for e := range queue {
+				h.run(ctx, e)
+			}
+		}(queues[i])
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for e := range events {
+			select {
+			case queues[shard(subject(e), len(queues))] <- e:
+			default:
+				h.log.Warnf("hooks: dropping %s of %s, the commands for it are falling behind", e.Type, subject(e))
+			}
+		}
+		for _, queue := range queues {
+			close(queue)
+		}
+	}()
+	h.log.Infoln("hooks: running commands on events")
+	return nil
+}
+
+// Stop kills the commands that are still running.
+func (h *HookRunner) Stop() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.cancel == nil {
+		return nil
+	}
+	h.cancel()
+	h.unsubscribe()
+	h.wg.Wait()
+	h.cancel = nil
+	return nil
+}
+
+func (h *HookRunner) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
+func (h *HookRunner) SetupAdminHandlers(a *admin.AdminSocket)                                   {}
+func (h *HookRunner) Health() error                                                             { return nil }
+
+func (h *HookRunner) IsStarted() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.cancel != nil
+}
+
+// run runs the commands for an event one after another. Commands are run
+// directly rather than by a shell, with their arguments split at spaces.
+func (h *HookRunner) run(ctx context.Context, e Event) {
+	var commands []string
+	commands = append(commands, h.config.Commands[e.Type]...)
+	commands = append(commands, h.config.Commands["*"]...)
+	for _, command := range commands {
+		args := strings.Fields(command)
+		if len(args) == 0 || ctx.Err() != nil {
+			continue
+		}
+		cctx, cancel := context.WithTimeout(ctx, time.Duration(h.config.Timeout)*time.Second)
+		cmd := exec.CommandContext(cctx, args[0], args[1:]...)
+		cmd.Env = hookEnviron(os.Environ(), e)
+		output, err := cmd.CombinedOutput()
+		cancel()
+		if err != nil {
+			h.log.Warnf("hooks: %s failed on %s: %v %s", command, e.Type, err, strings.TrimSpace(string(output)))
+		} else {
+			h.log.Debugf("hooks: %s ran on %s", command, e.Type)
+		}
+	}
+}
+
+// subject returns what event e is about, so that events about the same thing
+// are handled in order: the key of a peer or session, the URI of an autopeer,
+// or else the type of the event.
+func subject(e Event) string {
+	if key := e.Data["key"]; key != "" {
+		return key
+	}
+	if uri := e.Data["uri"]; uri != "" {
+		return uri
+	}
+	return e.Type
+}
+
+// shard returns which of n workers handles the events of subject.
+func shard(subject string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(subject))
+	return int(h.Sum32() % uint32(n))
+}
+
+// hookEnviron returns the environment of a hook command for event e: the
+// variables of environ that are listed in hookEnv, and those describing e.
+func hookEnviron(environ []string, e Event) []string {
+	var env []string
+	for _, kv := range environ {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			continue
+		}
+		for _, name := range hookEnv {
+			if sameEnvName(kv[:i], name) {
+				env = append(env, kv)
+			}
+		}
+	}
+	return append(env, e.Environ()...)
+}
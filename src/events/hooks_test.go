@@ -0,0 +1,77 @@
+package events
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/popura-network/Popura/src/popura"
+	"github.com/popura-network/Popura/src/sdnotify"
+)
+
+func TestHookEnviron(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"HOME=/var/lib/popura",
+		"LANG=C.UTF-8",
+		"PATHEXT=.exe",
+		popura.PrivateKeyEnv + "=secret",
+		sdnotify.SocketEnv + "=/run/systemd/notify",
+		"POPURA_EVENT_URI=forged",
+	}
+	e := Event{Type: PeerConnected, Time: time.Now(), Data: map[string]string{"uri": "tcp://[::1]:1"}}
+	got := make(map[string]string)
+	for _, kv := range hookEnviron(environ, e) {
+		i := strings.IndexByte(kv, '=')
+		if _, ok := got[kv[:i]]; ok {
+			t.Errorf("%s is set twice", kv[:i])
+		}
+		got[kv[:i]] = kv[i+1:]
+	}
+	want := map[string]string{
+		"PATH":             "/usr/bin",
+		"HOME":             "/var/lib/popura",
+		"LANG":             "C.UTF-8",
+		"POPURA_EVENT":     PeerConnected,
+		"POPURA_EVENT_URI": "tcp://[::1]:1",
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s is %q, want %q", name, got[name], value)
+		}
+	}
+	kept := []string{popura.PrivateKeyEnv, sdnotify.SocketEnv}
+	if runtime.GOOS == "windows" {
+		want["PATHEXT"] = ".exe"
+	} else {
+		kept = append(kept, "PATHEXT")
+	}
+	for _, name := range kept {
+		if _, ok := got[name]; ok {
+			t.Errorf("%s is passed on", name)
+		}
+	}
+}
+
+func TestSubject(t *testing.T) {
+	tests := []struct {
+		e    Event
+		want string
+	}{
+		{Event{Type: PeerConnected, Data: map[string]string{"uri": "tcp://[::1]:1", "key": "0102"}}, "0102"},
+		{Event{Type: SessionClosed, Data: map[string]string{"key": "0102"}}, "0102"},
+		{Event{Type: AutopeerRemoved, Data: map[string]string{"uri": "tcp://[::1]:1"}}, "tcp://[::1]:1"},
+		{Event{Type: MeshnameListenerFailed, Data: map[string]string{"error": "failed"}}, MeshnameListenerFailed},
+	}
+	for _, test := range tests {
+		if got := subject(test.e); got != test.want {
+			t.Errorf("%s: got subject %q, want %q", test.e.Type, got, test.want)
+		}
+	}
+	for n := 1; n <= 8; n++ {
+		if w := shard("0102", n); w < 0 || w >= n {
+			t.Errorf("got worker %d of %d", w, n)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package events
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+const pollInterval = time.Second
+
+// Monitor publishes an event whenever a peer connects or disconnects, or a
+// session is opened or closed. The core doesn't report these itself, so its
+// peers and sessions are polled instead. It also takes subscriptions to
+// events over the admin socket, which clients then fetch the events of.
+type Monitor struct {
+	core     *core.Core
+	log      *logging.Logger
+	bus      *Bus
+	lister   popura.PeerLister
+	peers    map[string]core.PeerInfo // keyed by peer key and remote address
+	sessions map[string]core.SessionInfo
+	stop     chan struct{}
+	done     chan struct{}
+	lock     sync.Mutex
+	subs     map[uint64]*subscription // by ID
+	lastSub  uint64
+	subLock  sync.Mutex
+}
+
+// MonitorOptions are the options of a Monitor.
+type MonitorOptions struct {
+	Bus   *Bus              // to publish events on
+	Peers popura.PeerLister // the core if nil
+}
+
+// Init takes MonitorOptions as its options.
+func (m *Monitor) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
+	m.core = yggcore
+	m.log = log
+	opts, _ := options.(MonitorOptions)
+	m.bus = opts.Bus
+	m.lister = opts.Peers
+	if m.lister == nil {
+		m.lister = yggcore
+	}
+	return nil
+}
+
+func (m *Monitor) Start() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stop != nil {
+		return errors.New("already started")
+	}
+	m.peers = make(map[string]core.PeerInfo)
+	m.sessions = make(map[string]core.SessionInfo)
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.run(m.stop, m.done)
+	return nil
+}
+
+func (m *Monitor) Stop() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stop == nil {
+		return nil
+	}
+	close(m.stop)
+	<-m.done
+	m.stop = nil
+	m.endSubscriptions()
+	return nil
+}
+
+func (m *Monitor) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
+func (m *Monitor) Health() error                                                             { return nil }
+
+func (m *Monitor) IsStarted() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stop != nil
+}
+
+func (m *Monitor) run(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		m.poll()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll compares the peers and sessions of the core with those seen before.
+func (m *Monitor) poll() {
+	current := make(map[string]core.PeerInfo)
+	for _, p := range m.lister.GetPeers() {
+		current[hex.EncodeToString(p.Key)+" "+p.Remote] = p
+	}
+	for id, p := range current {
+		if _, ok := m.peers[id]; !ok {
+			m.log.Debugln("events: peer connected:", p.Remote)
+			m.bus.Publish(PeerConnected, peerData(p))
+		}
+	}
+	for id, p := range m.peers {
+		if _, ok := current[id]; !ok {
+			m.log.Debugln("events: peer disconnected:", p.Remote)
+			m.bus.Publish(PeerDisconnected, peerData(p))
+		}
+	}
+	m.peers = current
+
+	sessions := make(map[string]core.SessionInfo)
+	for _, s := range m.core.GetSessions() {
+		sessions[hex.EncodeToString(s.Key)] = s
+	}
+	for id, s := range sessions {
+		if _, ok := m.sessions[id]; !ok {
+			m.bus.Publish(SessionOpened, sessionData(s))
+		}
+	}
+	for id, s := range m.sessions {
+		if _, ok := sessions[id]; !ok {
+			m.bus.Publish(SessionClosed, sessionData(s))
+		}
+	}
+	m.sessions = sessions
+}
+
+func peerData(p core.PeerInfo) map[string]string {
+	addr := address.AddrForKey(p.Key)
+	return map[string]string{
+		"uri":     p.Remote,
+		"key":     hex.EncodeToString(p.Key),
+		"address": net.IP(addr[:]).String(),
+		"port":    strconv.FormatUint(p.Port, 10),
+	}
+}
+
+func sessionData(s core.SessionInfo) map[string]string {
+	addr := address.AddrForKey(s.Key)
+	return map[string]string{
+		"key":     hex.EncodeToString(s.Key),
+		"address": net.IP(addr[:]).String(),
+	}
+}
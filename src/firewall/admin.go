@@ -0,0 +1,104 @@
+package firewall
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+type GetFirewallRequest struct{}
+type GetFirewallResponse struct {
+	Enabled     bool        `json:"enabled"`
+	Default     string      `json:"default,omitempty"`
+	Rules       []RuleEntry `json:"rules"`
+	DefaultHits uint64      `json:"default_hits"`
+	StateHits   uint64      `json:"state_hits"`
+	Invalid     uint64      `json:"invalid"`
+	Flows       int         `json:"flows"`
+}
+
+// RuleEntry is a rule of Popura.Firewall.Rules and the number of packets it
+// has matched.
+type RuleEntry struct {
+	Action   string `json:"action"`
+	Key      string `json:"key,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Port     string `json:"port,omitempty"`
+	Hits     uint64 `json:"hits"`
+}
+
+type ResetFirewallCountersRequest struct{}
+type ResetFirewallCountersResponse struct{}
+
+func (f *Firewall) getFirewallHandler(req *GetFirewallRequest, res *GetFirewallResponse) error {
+	res.Enabled = f.config.Enable
+	res.Rules = make([]RuleEntry, 0, len(f.rules))
+	if !res.Enabled {
+		return nil
+	}
+	res.Default = f.defaultAction()
+	for _, r := range f.rules {
+		action := ActionDrop
+		if r.accept {
+			action = ActionAccept
+		}
+		res.Rules = append(res.Rules, RuleEntry{
+			Action:   action,
+			Key:      r.config.Key,
+			Source:   r.config.Source,
+			Protocol: r.config.Protocol,
+			Port:     r.config.Port,
+			Hits:     atomic.LoadUint64(&r.hits),
+		})
+	}
+	res.DefaultHits = atomic.LoadUint64(&f.counters.dflt)
+	res.StateHits = atomic.LoadUint64(&f.counters.state)
+	res.Invalid = atomic.LoadUint64(&f.counters.invalid)
+	f.lock.Lock()
+	res.Flows = len(f.flows)
+	f.lock.Unlock()
+	return nil
+}
+
+func (f *Firewall) resetFirewallCountersHandler(req *ResetFirewallCountersRequest, res *ResetFirewallCountersResponse) error {
+	for _, r := range f.rules {
+		atomic.StoreUint64(&r.hits, 0)
+	}
+	atomic.StoreUint64(&f.counters.dflt, 0)
+	atomic.StoreUint64(&f.counters.state, 0)
+	atomic.StoreUint64(&f.counters.invalid, 0)
+	return nil
+}
+
+func (f *Firewall) SetupAdminHandlers(a *admin.AdminSocket) {
+	_ = a.AddHandler(
+		"getFirewall", "Show the rules of the firewall and the number of packets each has matched", []string{},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &GetFirewallRequest{}
+			res := &GetFirewallResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := f.getFirewallHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"resetFirewallCounters", "Set the numbers of packets matched by the firewall back to zero", []string{},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &ResetFirewallCountersRequest{}
+			res := &ResetFirewallCountersResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := f.resetFirewallCountersHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+}
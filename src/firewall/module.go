@@ -0,0 +1,282 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+	"github.com/popura-network/Popura/src/tun"
+)
+
+const (
+	tcpFlowTimeout  = time.Hour
+	flowTimeout     = 2 * time.Minute
+	fragmentTimeout = time.Minute // as long as a packet may take to reassemble
+	purgeInterval   = time.Minute
+)
+
+// flow is a connection opened from this node, by which packets from the
+// network are accepted whatever the rules say.
+type flow struct {
+	proto                 uint8
+	local, remote         [16]byte
+	localPort, remotePort uint16
+}
+
+// fragments identifies the fragments of a packet from the network.
+type fragments struct {
+	src, dst [16]byte
+	id       uint32
+}
+
+// counters are updated atomically, and kept first in Firewall to be aligned.
+type counters struct {
+	state   uint64 // packets accepted as part of a flow
+	dflt    uint64 // packets that matched no rule
+	invalid uint64 // packets that couldn't be parsed, which are dropped
+}
+
+// Firewall filters the packets that arrive from the network before they reach
+// the TUN adapter. Packets that belong to a connection opened from this node
+// are accepted, others by the first of the rules that matches them, or else
+// by the default action. Fragments after the first have no ports, so they are
+// accepted if the first fragment of their packet was, or else by the rules
+// like any other packet.
+type Firewall struct {
+	counters counters
+	log      *logging.Logger
+	config   popura.FirewallConfig
+	accept   bool // the default action
+	rules    []*rule
+	flows    map[flow]time.Time      // by flow, when it expires
+	accepted map[fragments]time.Time // by fragmented packet, when it expires
+	done     chan struct{}
+	lock     sync.Mutex
+}
+
+func (f *Firewall) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
+	f.log = log
+	f.config = popConfig.Firewall
+	if !f.config.Enable {
+		return nil
+	}
+	switch strings.ToLower(f.config.Default) {
+	case ActionAccept:
+		f.accept = true
+	case ActionDrop:
+	default:
+		return fmt.Errorf("Default must be %q or %q", ActionAccept, ActionDrop)
+	}
+	f.rules = make([]*rule, 0, len(f.config.Rules))
+	for i, config := range f.config.Rules {
+		r, err := parseRule(config)
+		if err != nil {
+			return fmt.Errorf("Rules[%d]: %w", i, err)
+		}
+		f.rules = append(f.rules, r)
+	}
+	f.flows = make(map[flow]time.Time)
+	f.accepted = make(map[fragments]time.Time)
+	return nil
+}
+
+func (f *Firewall) Start() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.config.Enable {
+		return nil
+	}
+	if f.done != nil {
+		return errors.New("already started")
+	}
+	f.done = make(chan struct{})
+	go f.purgeLoop(f.done)
+	f.log.Infof("firewall: filtering packets with %d rules, %s by default", len(f.rules), f.defaultAction())
+	return nil
+}
+
+func (f *Firewall) Stop() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.done != nil {
+		close(f.done)
+		f.done = nil
+	}
+	return nil
+}
+
+func (f *Firewall) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
+
+func (f *Firewall) IsStarted() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.done != nil
+}
+
+func (f *Firewall) Health() error {
+	if f.config.Enable && !f.IsStarted() {
+		return errors.New("firewall is not running")
+	}
+	return nil
+}
+
+func (f *Firewall) defaultAction() string {
+	if f.accept {
+		return ActionAccept
+	}
+	return ActionDrop
+}
+
+// ReadWriteCloser returns rwc filtered by the firewall, or rwc itself if the
+// firewall isn't enabled.
+func (f *Firewall) ReadWriteCloser(rwc tun.ReadWriteCloser) tun.ReadWriteCloser {
+	if !f.config.Enable {
+		return rwc
+	}
+	return &filter{ReadWriteCloser: rwc, firewall: f}
+}
+
+// filter is a tun.ReadWriteCloser that drops the packets read from the
+// network that the firewall doesn't accept, and tracks the flows of the
+// packets written to it.
+type filter struct {
+	tun.ReadWriteCloser
+	firewall *Firewall
+}
+
+func (fi *filter) Read(p []byte) (int, error) {
+	for {
+		n, err := fi.ReadWriteCloser.Read(p)
+		if err != nil || fi.firewall.allow(p[:n]) {
+			return n, err
+		}
+	}
+}
+
+func (fi *filter) Write(p []byte) (int, error) {
+	fi.firewall.track(p)
+	return fi.ReadWriteCloser.Write(p)
+}
+
+// track records the flow of a packet sent to the network.
+func (f *Firewall) track(bs []byte) {
+	p, ok := parsePacket(bs)
+	if !ok || p.later || p.isError() || (p.proto == protoICMPv6 && p.icmpType != icmpEchoRequest) {
+		return
+	}
+	fl := flow{proto: p.proto, local: p.src, remote: p.dst, localPort: p.srcPort, remotePort: p.dstPort}
+	f.lock.Lock()
+	f.flows[fl] = time.Now().Add(timeoutOf(p.proto))
+	f.lock.Unlock()
+}
+
+// allow reports whether a packet from the network may reach the TUN adapter.
+func (f *Firewall) allow(bs []byte) bool {
+	p, ok := parsePacket(bs)
+	if !ok {
+		atomic.AddUint64(&f.counters.invalid, 1)
+		return false
+	}
+	if f.related(&p) {
+		atomic.AddUint64(&f.counters.state, 1)
+		f.acceptFragments(&p)
+		return true
+	}
+	for _, r := range f.rules {
+		if r.match(&p) {
+			if r.accept {
+				f.acceptFragments(&p)
+			}
+			return r.accept
+		}
+	}
+	atomic.AddUint64(&f.counters.dflt, 1)
+	if f.accept {
+		f.acceptFragments(&p)
+	}
+	return f.accept
+}
+
+// acceptFragments records that the fragments after p are to be accepted, if p
+// is the first fragment of a packet.
+func (f *Firewall) acceptFragments(p *packet) {
+	if !p.fragment || p.later {
+		return
+	}
+	id := fragments{src: p.src, dst: p.dst, id: p.fragID}
+	f.lock.Lock()
+	f.accepted[id] = time.Now().Add(fragmentTimeout)
+	f.lock.Unlock()
+}
+
+// related reports whether a packet from the network belongs to a flow, is
+// an ICMPv6 error about a packet of one, or is a later fragment of a packet
+// whose first fragment was accepted.
+func (f *Firewall) related(p *packet) bool {
+	if p.later {
+		id := fragments{src: p.src, dst: p.dst, id: p.fragID}
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		expiry, ok := f.accepted[id]
+		return ok && !time.Now().After(expiry)
+	}
+	fl := flow{proto: p.proto, local: p.dst, remote: p.src, localPort: p.dstPort, remotePort: p.srcPort}
+	if p.isError() {
+		inner, ok := parsePacket(p.payload)
+		if !ok || inner.src != p.dst {
+			return false
+		}
+		fl = flow{proto: inner.proto, local: inner.src, remote: inner.dst, localPort: inner.srcPort, remotePort: inner.dstPort}
+	}
+	now := time.Now()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	expiry, ok := f.flows[fl]
+	if !ok || now.After(expiry) {
+		return false
+	}
+	if !p.isError() {
+		f.flows[fl] = now.Add(timeoutOf(p.proto))
+	}
+	return true
+}
+
+// timeoutOf returns how long a flow of proto lasts without packets.
+func timeoutOf(proto uint8) time.Duration {
+	if proto == protoTCP {
+		return tcpFlowTimeout
+	}
+	return flowTimeout
+}
+
+func (f *Firewall) purgeLoop(done chan struct{}) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			f.lock.Lock()
+			for fl, expiry := range f.flows {
+				if now.After(expiry) {
+					delete(f.flows, fl)
+				}
+			}
+			for id, expiry := range f.accepted {
+				if now.After(expiry) {
+					delete(f.accepted, id)
+				}
+			}
+			f.lock.Unlock()
+		}
+	}
+}
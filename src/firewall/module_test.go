@@ -0,0 +1,80 @@
+package firewall
+
+import (
+	"io"
+	"testing"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+func TestFlows(t *testing.T) {
+	const local, remote, stranger = "200::1", "201::2", "202::3"
+	config := popura.GenerateConfig()
+	config.Firewall.Enable = true
+	config.Firewall.Default = ActionDrop
+	config.Firewall.Rules = []popura.FirewallRule{
+		{Action: ActionAccept, Protocol: "tcp", Port: "22"},
+		{Action: ActionAccept, Protocol: "udp", Source: "203::/16"},
+	}
+	f := &Firewall{}
+	if err := f.Init(nil, nil, config, logging.New(io.Discard, "text").Logger("firewall"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each step sends a packet to the network if out is set, or else checks
+	// whether one from the network is accepted, in order.
+	steps := []struct {
+		name   string
+		out    bool
+		packet []byte
+		accept bool
+	}{
+		{"unsolicited tcp", false, ipv6(remote, local, protoTCP, ports(80, 1000)), false},
+		{"tcp out", true, ipv6(local, remote, protoTCP, ports(1000, 80)), false},
+		{"tcp reply", false, ipv6(remote, local, protoTCP, ports(80, 1000)), true},
+		{"tcp from another port", false, ipv6(remote, local, protoTCP, ports(81, 1000)), false},
+		{"tcp to another port", false, ipv6(remote, local, protoTCP, ports(80, 1001)), false},
+		{"tcp from another node", false, ipv6(stranger, local, protoTCP, ports(80, 1000)), false},
+		{"udp to the same ports", false, ipv6(remote, local, protoUDP, ports(80, 1000)), false},
+		{"error about tcp", false, ipv6(stranger, local, protoICMPv6, icmp(1, 0, ipv6(local, remote, protoTCP, ports(1000, 80)))), true},
+		{"error about another flow", false, ipv6(stranger, local, protoICMPv6, icmp(1, 0, ipv6(local, remote, protoTCP, ports(1000, 81)))), false},
+		{"error about another's packet", false, ipv6(stranger, local, protoICMPv6, icmp(1, 0, ipv6(stranger, remote, protoTCP, ports(1000, 80)))), false},
+		{"unsolicited echo reply", false, ipv6(remote, local, protoICMPv6, icmp(icmpEchoReply, 5, nil)), false},
+		{"echo request out", true, ipv6(local, remote, protoICMPv6, icmp(icmpEchoRequest, 5, nil)), false},
+		{"echo reply", false, ipv6(remote, local, protoICMPv6, icmp(icmpEchoReply, 5, nil)), true},
+		{"echo reply to another request", false, ipv6(remote, local, protoICMPv6, icmp(icmpEchoReply, 6, nil)), false},
+		{"rule", false, ipv6(stranger, local, protoTCP, ports(40000, 22)), true},
+
+		{"udp out", true, ipv6(local, remote, protoUDP, ports(2000, 53)), false},
+		{"later fragment out", true, ipv6(local, remote, protoFragment, fragment(protoUDP, 100, false, 1)), false},
+		{"first fragment of reply", false, ipv6(remote, local, protoFragment, fragment(protoUDP, 0, true, 7), ports(53, 2000)), true},
+		{"later fragment of reply", false, ipv6(remote, local, protoFragment, fragment(protoUDP, 181, true, 7)), true},
+		{"last fragment of reply", false, ipv6(remote, local, protoFragment, fragment(protoUDP, 362, false, 7)), true},
+		{"fragment of another packet", false, ipv6(remote, local, protoFragment, fragment(protoUDP, 181, false, 8)), false},
+		{"fragment from another node", false, ipv6(stranger, local, protoFragment, fragment(protoUDP, 181, false, 7)), false},
+		{"first fragment dropped", false, ipv6(stranger, local, protoFragment, fragment(protoUDP, 0, true, 9), ports(53, 2000)), false},
+		{"later fragment of dropped", false, ipv6(stranger, local, protoFragment, fragment(protoUDP, 181, false, 9)), false},
+		{"first fragment by rule", false, ipv6(stranger, local, protoFragment, fragment(protoTCP, 0, true, 10), ports(40000, 22)), true},
+		{"later fragment by rule", false, ipv6(stranger, local, protoFragment, fragment(protoTCP, 181, false, 10)), true},
+		{"later fragment by rule without port", false, ipv6("203::1", local, protoFragment, fragment(protoUDP, 181, false, 11)), true},
+		{"invalid", false, ipv6(remote, local, protoTCP, []byte{1}), false},
+	}
+	for _, step := range steps {
+		if step.out {
+			f.track(step.packet)
+			continue
+		}
+		if got := f.allow(step.packet); got != step.accept {
+			t.Errorf("%s: got accept %v, want %v", step.name, got, step.accept)
+		}
+	}
+	if f.counters.invalid != 1 {
+		t.Errorf("got %d invalid packets, want 1", f.counters.invalid)
+	}
+	for fl := range f.flows {
+		if fl.proto == protoUDP && fl.localPort == 0 {
+			t.Errorf("a later fragment was tracked as a flow")
+		}
+	}
+}
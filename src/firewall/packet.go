@@ -0,0 +1,101 @@
+package firewall
+
+import (
+	"encoding/binary"
+)
+
+const (
+	protoHopByHop  = 0
+	protoTCP       = 6
+	protoUDP       = 17
+	protoRouting   = 43
+	protoFragment  = 44
+	protoICMPv6    = 58
+	protoDestOpts  = 60
+	ipv6HeaderSize = 40
+
+	icmpEchoRequest = 128
+	icmpEchoReply   = 129
+)
+
+// packet is what the firewall needs to know of an IPv6 packet.
+type packet struct {
+	src, dst [16]byte
+	proto    uint8
+	srcPort  uint16 // both are the identifier of an ICMPv6 echo
+	dstPort  uint16
+	ports    bool // whether srcPort and dstPort are known
+	icmpType uint8
+	payload  []byte // after the transport header, for ICMPv6 errors
+	fragment bool   // whether the packet is a fragment
+	fragID   uint32 // identification of the packet it is a fragment of
+	later    bool   // whether it is a fragment after the first
+}
+
+// parsePacket parses the headers of an IPv6 packet, skipping extension
+// headers, and reports whether they could be. Fragments after the first have
+// no transport header, so no ports.
+func parsePacket(bs []byte) (p packet, ok bool) {
+	if len(bs) < ipv6HeaderSize || bs[0]&0xf0 != 0x60 {
+		return p, false
+	}
+	copy(p.src[:], bs[8:24])
+	copy(p.dst[:], bs[24:40])
+	next, rest := bs[6], bs[ipv6HeaderSize:]
+headers:
+	for {
+		switch next {
+		case protoHopByHop, protoRouting, protoDestOpts:
+			if len(rest) < 8 {
+				return p, false
+			}
+			size := (int(rest[1]) + 1) * 8
+			if len(rest) < size {
+				return p, false
+			}
+			next, rest = rest[0], rest[size:]
+		case protoFragment:
+			if len(rest) < 8 {
+				return p, false
+			}
+			p.fragment = true
+			p.fragID = binary.BigEndian.Uint32(rest[4:8])
+			if binary.BigEndian.Uint16(rest[2:4])&^7 != 0 {
+				p.proto = rest[0]
+				p.later = true
+				return p, true
+			}
+			next, rest = rest[0], rest[8:]
+		default:
+			break headers
+		}
+	}
+	p.proto = next
+	switch p.proto {
+	case protoTCP, protoUDP:
+		if len(rest) < 4 {
+			return p, false
+		}
+		p.srcPort = binary.BigEndian.Uint16(rest[0:2])
+		p.dstPort = binary.BigEndian.Uint16(rest[2:4])
+		p.ports = true
+	case protoICMPv6:
+		if len(rest) < 8 {
+			return p, false
+		}
+		p.icmpType = rest[0]
+		if p.icmpType == icmpEchoRequest || p.icmpType == icmpEchoReply {
+			p.srcPort = binary.BigEndian.Uint16(rest[4:6])
+			p.dstPort = p.srcPort
+			p.ports = true
+		}
+		p.payload = rest[8:]
+	}
+	return p, true
+}
+
+// isError reports whether p is an ICMPv6 error message, which carries the
+// start of the packet that caused it.
+func (p *packet) isError() bool {
+	return p.proto == protoICMPv6 && p.icmpType < 128
+}
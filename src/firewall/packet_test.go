@@ -0,0 +1,108 @@
+package firewall
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// ipv6 returns a packet from src to dst whose first header is next, followed
+// by headers.
+func ipv6(src, dst string, next uint8, headers ...[]byte) []byte {
+	payload := bytes.Join(headers, nil)
+	bs := make([]byte, ipv6HeaderSize, ipv6HeaderSize+len(payload))
+	bs[0] = 0x60
+	binary.BigEndian.PutUint16(bs[4:6], uint16(len(payload)))
+	bs[6] = next
+	bs[7] = 64
+	copy(bs[8:24], net.ParseIP(src))
+	copy(bs[24:40], net.ParseIP(dst))
+	return append(bs, payload...)
+}
+
+// ports returns a TCP or UDP header, as far as the firewall reads it.
+func ports(src, dst uint16) []byte {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint16(bs[0:2], src)
+	binary.BigEndian.PutUint16(bs[2:4], dst)
+	return bs
+}
+
+// icmp returns an ICMPv6 header followed by payload.
+func icmp(typ uint8, id uint16, payload []byte) []byte {
+	bs := make([]byte, 8)
+	bs[0] = typ
+	binary.BigEndian.PutUint16(bs[4:6], id)
+	return append(bs, payload...)
+}
+
+// extension returns an extension header of 8 bytes.
+func extension(next uint8) []byte {
+	return []byte{next, 0, 0, 0, 0, 0, 0, 0}
+}
+
+// fragment returns a fragment header.
+func fragment(next uint8, offset uint16, more bool, id uint32) []byte {
+	bs := make([]byte, 8)
+	bs[0] = next
+	flags := offset << 3
+	if more {
+		flags |= 1
+	}
+	binary.BigEndian.PutUint16(bs[2:4], flags)
+	binary.BigEndian.PutUint32(bs[4:8], id)
+	return bs
+}
+
+func TestParsePacket(t *testing.T) {
+	const a, b = "200::1", "201::2"
+	tests := []struct {
+		name string
+		bs   []byte
+		ok   bool
+		want packet
+	}{
+		{"tcp", ipv6(a, b, protoTCP, ports(1000, 80)), true,
+			packet{proto: protoTCP, srcPort: 1000, dstPort: 80, ports: true}},
+		{"udp", ipv6(a, b, protoUDP, ports(1000, 53)), true,
+			packet{proto: protoUDP, srcPort: 1000, dstPort: 53, ports: true}},
+		{"echo request", ipv6(a, b, protoICMPv6, icmp(icmpEchoRequest, 7, nil)), true,
+			packet{proto: protoICMPv6, icmpType: icmpEchoRequest, srcPort: 7, dstPort: 7, ports: true}},
+		{"icmp error", ipv6(a, b, protoICMPv6, icmp(1, 0, []byte{1, 2})), true,
+			packet{proto: protoICMPv6, icmpType: 1}},
+		{"extension headers", ipv6(a, b, protoHopByHop, extension(protoDestOpts), extension(protoTCP), ports(1000, 80)), true,
+			packet{proto: protoTCP, srcPort: 1000, dstPort: 80, ports: true}},
+		{"first fragment", ipv6(a, b, protoFragment, fragment(protoUDP, 0, true, 9), ports(1000, 53)), true,
+			packet{proto: protoUDP, srcPort: 1000, dstPort: 53, ports: true, fragment: true, fragID: 9}},
+		{"later fragment", ipv6(a, b, protoFragment, fragment(protoUDP, 181, true, 9), []byte{1, 2, 3, 4, 5, 6, 7, 8}), true,
+			packet{proto: protoUDP, fragment: true, fragID: 9, later: true}},
+		{"last fragment", ipv6(a, b, protoFragment, fragment(protoTCP, 362, false, 9)), true,
+			packet{proto: protoTCP, fragment: true, fragID: 9, later: true}},
+		{"other protocol", ipv6(a, b, 50, []byte{1, 2, 3, 4}), true,
+			packet{proto: 50}},
+		{"too short", ipv6(a, b, protoTCP)[:ipv6HeaderSize-1], false, packet{}},
+		{"IPv4", append([]byte{0x45}, ipv6(a, b, protoTCP, ports(1, 2))[1:]...), false, packet{}},
+		{"short transport header", ipv6(a, b, protoTCP, []byte{1, 2, 3}), false, packet{}},
+		{"short icmp header", ipv6(a, b, protoICMPv6, []byte{128, 0, 0, 0}), false, packet{}},
+		{"short extension header", ipv6(a, b, protoHopByHop, []byte{protoTCP, 1, 0, 0, 0, 0, 0, 0}), false, packet{}},
+		{"short fragment header", ipv6(a, b, protoFragment, []byte{protoTCP, 0, 0}), false, packet{}},
+	}
+	for _, test := range tests {
+		p, ok := parsePacket(test.bs)
+		if ok != test.ok {
+			t.Errorf("%s: got ok %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		p.payload = nil
+		copy(test.want.src[:], net.ParseIP(a))
+		copy(test.want.dst[:], net.ParseIP(b))
+		if !reflect.DeepEqual(p, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, p, test.want)
+		}
+	}
+}
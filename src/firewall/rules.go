@@ -0,0 +1,129 @@
+package firewall
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+
+	"github.com/popura-network/Popura/src/popura"
+)
+
+const (
+	ActionAccept = "accept"
+	ActionDrop   = "drop"
+)
+
+var protocols = map[string]uint8{
+	"tcp":    protoTCP,
+	"udp":    protoUDP,
+	"icmpv6": protoICMPv6,
+}
+
+// rule is a parsed popura.FirewallRule. Its zero values match any packet.
+type rule struct {
+	hits    uint64 // first to be aligned for atomic updates
+	config  popura.FirewallRule
+	accept  bool
+	keyAddr *address.Address // address and subnet of Key
+	keyNet  *address.Subnet
+	source  *net.IPNet
+	proto   uint8
+	portLo  uint16
+	portHi  uint16
+}
+
+// parseRule parses a rule of Popura.Firewall.Rules.
+func parseRule(config popura.FirewallRule) (*rule, error) {
+	r := &rule{config: config}
+	switch strings.ToLower(config.Action) {
+	case ActionAccept:
+		r.accept = true
+	case ActionDrop:
+	default:
+		return nil, fmt.Errorf("Action must be %q or %q", ActionAccept, ActionDrop)
+	}
+	if config.Key != "" {
+		key, err := hex.DecodeString(config.Key)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("Key must be a public key in hex")
+		}
+		r.keyAddr, r.keyNet = address.AddrForKey(key), address.SubnetForKey(key)
+	}
+	if config.Source != "" {
+		_, source, err := net.ParseCIDR(config.Source)
+		if err != nil || source.IP.To4() != nil {
+			return nil, errors.New("Source must be an IPv6 subnet, e.g. 200::/7")
+		}
+		r.source = source
+	}
+	if config.Protocol != "" {
+		proto, ok := protocols[strings.ToLower(config.Protocol)]
+		if !ok {
+			return nil, errors.New("Protocol must be \"tcp\", \"udp\" or \"icmpv6\"")
+		}
+		r.proto = proto
+	}
+	if config.Port != "" {
+		if r.proto != protoTCP && r.proto != protoUDP {
+			return nil, errors.New("Port requires the Protocol \"tcp\" or \"udp\"")
+		}
+		lo, hi, err := parsePorts(config.Port)
+		if err != nil {
+			return nil, err
+		}
+		r.portLo, r.portHi = lo, hi
+	}
+	return r, nil
+}
+
+// CheckRule checks a rule of Popura.Firewall.Rules.
+func CheckRule(config popura.FirewallRule) error {
+	_, err := parseRule(config)
+	return err
+}
+
+// parsePorts parses a port or a range of ports such as "8000-8100".
+func parsePorts(s string) (lo, hi uint16, err error) {
+	first, last := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		first, last = s[:i], s[i+1:]
+	}
+	l, err1 := strconv.ParseUint(first, 10, 16)
+	h, err2 := strconv.ParseUint(last, 10, 16)
+	if err1 != nil || err2 != nil || l == 0 || l > h {
+		return 0, 0, errors.New("Port must be a port or a range of ports, e.g. \"8000-8100\"")
+	}
+	return uint16(l), uint16(h), nil
+}
+
+// match reports whether the rule matches a packet from the network. The
+// sender of a packet is known by its source address, which the core has
+// already checked belongs to the key it came from.
+func (r *rule) match(p *packet) bool {
+	if r.keyAddr != nil {
+		var addr address.Address
+		var subnet address.Subnet
+		copy(addr[:], p.src[:])
+		copy(subnet[:], p.src[:])
+		if addr != *r.keyAddr && subnet != *r.keyNet {
+			return false
+		}
+	}
+	if r.source != nil && !r.source.Contains(p.src[:]) {
+		return false
+	}
+	if r.proto != 0 && r.proto != p.proto {
+		return false
+	}
+	if r.portLo != 0 && (!p.ports || p.dstPort < r.portLo || p.dstPort > r.portHi) {
+		return false
+	}
+	atomic.AddUint64(&r.hits, 1)
+	return true
+}
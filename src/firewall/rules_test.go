@@ -0,0 +1,96 @@
+package firewall
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+
+	"github.com/popura-network/Popura/src/popura"
+)
+
+func TestParseRule(t *testing.T) {
+	key := hex.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	tests := []struct {
+		name string
+		rule popura.FirewallRule
+		ok   bool
+	}{
+		{"accept all", popura.FirewallRule{Action: "accept"}, true},
+		{"drop in capitals", popura.FirewallRule{Action: "DROP"}, true},
+		{"every field", popura.FirewallRule{Action: "accept", Key: key, Source: "200::/7", Protocol: "TCP", Port: "8000-8100"}, true},
+		{"udp port", popura.FirewallRule{Action: "accept", Protocol: "udp", Port: "53"}, true},
+		{"no action", popura.FirewallRule{}, false},
+		{"unknown action", popura.FirewallRule{Action: "reject"}, false},
+		{"short key", popura.FirewallRule{Action: "accept", Key: "0102"}, false},
+		{"key not hex", popura.FirewallRule{Action: "accept", Key: "key"}, false},
+		{"IPv4 source", popura.FirewallRule{Action: "accept", Source: "10.0.0.0/8"}, false},
+		{"source without prefix", popura.FirewallRule{Action: "accept", Source: "200::1"}, false},
+		{"unknown protocol", popura.FirewallRule{Action: "accept", Protocol: "sctp"}, false},
+		{"port without protocol", popura.FirewallRule{Action: "accept", Port: "22"}, false},
+		{"icmpv6 port", popura.FirewallRule{Action: "accept", Protocol: "icmpv6", Port: "22"}, false},
+		{"port 0", popura.FirewallRule{Action: "accept", Protocol: "tcp", Port: "0"}, false},
+		{"reversed range", popura.FirewallRule{Action: "accept", Protocol: "tcp", Port: "8100-8000"}, false},
+		{"port too high", popura.FirewallRule{Action: "accept", Protocol: "tcp", Port: "65536"}, false},
+	}
+	for _, test := range tests {
+		if _, err := parseRule(test.rule); (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	addr := address.AddrForKey(key)
+	subnet := address.SubnetForKey(key)
+	keyAddr := net.IP(addr[:]).String()
+	host := make(net.IP, 16)
+	copy(host, subnet[:])
+	host[15] = 1
+	keyHost := host.String()
+	const local, other = "200::1", "201::2"
+
+	tests := []struct {
+		name   string
+		rule   popura.FirewallRule
+		packet []byte
+		match  bool
+	}{
+		{"any", popura.FirewallRule{Action: "accept"}, ipv6(other, local, protoUDP, ports(1, 2)), true},
+		{"key address", popura.FirewallRule{Action: "accept", Key: hex.EncodeToString(key)}, ipv6(keyAddr, local, protoTCP, ports(1, 2)), true},
+		{"key subnet", popura.FirewallRule{Action: "accept", Key: hex.EncodeToString(key)}, ipv6(keyHost, local, protoTCP, ports(1, 2)), true},
+		{"other key", popura.FirewallRule{Action: "accept", Key: hex.EncodeToString(key)}, ipv6(other, local, protoTCP, ports(1, 2)), false},
+		{"in source", popura.FirewallRule{Action: "accept", Source: "201::/16"}, ipv6(other, local, protoTCP, ports(1, 2)), true},
+		{"not in source", popura.FirewallRule{Action: "accept", Source: "300::/8"}, ipv6(other, local, protoTCP, ports(1, 2)), false},
+		{"protocol", popura.FirewallRule{Action: "accept", Protocol: "icmpv6"}, ipv6(other, local, protoICMPv6, icmp(icmpEchoRequest, 1, nil)), true},
+		{"other protocol", popura.FirewallRule{Action: "accept", Protocol: "udp"}, ipv6(other, local, protoTCP, ports(1, 2)), false},
+		{"port", popura.FirewallRule{Action: "accept", Protocol: "tcp", Port: "22"}, ipv6(other, local, protoTCP, ports(40000, 22)), true},
+		{"source port", popura.FirewallRule{Action: "accept", Protocol: "tcp", Port: "22"}, ipv6(other, local, protoTCP, ports(22, 40000)), false},
+		{"first of range", popura.FirewallRule{Action: "accept", Protocol: "udp", Port: "8000-8100"}, ipv6(other, local, protoUDP, ports(1, 8000)), true},
+		{"last of range", popura.FirewallRule{Action: "accept", Protocol: "udp", Port: "8000-8100"}, ipv6(other, local, protoUDP, ports(1, 8100)), true},
+		{"after range", popura.FirewallRule{Action: "accept", Protocol: "udp", Port: "8000-8100"}, ipv6(other, local, protoUDP, ports(1, 8101)), false},
+		{"later fragment with port", popura.FirewallRule{Action: "accept", Protocol: "udp", Port: "53"},
+			ipv6(other, local, protoFragment, fragment(protoUDP, 100, false, 1)), false},
+		{"later fragment without port", popura.FirewallRule{Action: "accept", Protocol: "udp"},
+			ipv6(other, local, protoFragment, fragment(protoUDP, 100, false, 1)), true},
+	}
+	for _, test := range tests {
+		r, err := parseRule(test.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		p, ok := parsePacket(test.packet)
+		if !ok {
+			t.Fatalf("%s: invalid packet", test.name)
+		}
+		if got := r.match(&p); got != test.match {
+			t.Errorf("%s: got match %v, want %v", test.name, got, test.match)
+		}
+		if hits := r.hits; (hits == 1) != test.match {
+			t.Errorf("%s: got %d hits", test.name, hits)
+		}
+	}
+}
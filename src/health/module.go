@@ -0,0 +1,243 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+	"github.com/popura-network/Popura/src/tun"
+)
+
+const (
+	livenessPath    = "/healthz"
+	readinessPath   = "/readyz"
+	coreTimeout     = 5 * time.Second
+	shutdownTimeout = 5 * time.Second
+)
+
+// Options are passed to HealthServer.Init to check the other parts of the
+// node. Any of them may be nil.
+type Options struct {
+	Admin   *admin.AdminSocket
+	TUN     *tun.TunAdapter
+	Modules map[string]popura.Module
+}
+
+// Check is the result of a single check.
+type Check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the response to a probe, listing the result of each check.
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// HealthServer serves liveness and readiness probes over HTTP, for
+// orchestrators such as Kubernetes. The node is live while every part of it
+// that was started is working, and ready once it also has enough peers. The
+// liveness check is also what the node reports to the service manager.
+type HealthServer struct {
+	core       *core.Core
+	log        *logging.Logger
+	config     popura.HealthConfig
+	options    Options
+	tunEnabled bool
+	server     *http.Server
+	lock       sync.RWMutex
+}
+
+func (h *HealthServer) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
+	h.core = yggcore
+	h.log = log
+	h.config = popConfig.Health
+	h.tunEnabled = yggConfig.IfName != "none" && yggConfig.IfName != "dummy"
+	if opts, ok := options.(Options); ok {
+		h.options = opts
+	}
+	if h.config.Enable {
+		if _, _, err := net.SplitHostPort(h.config.Listen); err != nil {
+			return err
+		}
+		if h.config.MinPeers < 0 {
+			return errors.New("MinPeers must not be negative")
+		}
+	}
+	return nil
+}
+
+func (h *HealthServer) Start() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.config.Enable {
+		return nil
+	}
+	if h.server != nil {
+		return errors.New("already started")
+	}
+	listener, err := net.Listen("tcp", h.config.Listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(livenessPath, func(rw http.ResponseWriter, r *http.Request) {
+		h.serveReport(rw, h.Liveness())
+	})
+	mux.HandleFunc(readinessPath, func(rw http.ResponseWriter, r *http.Request) {
+		h.serveReport(rw, h.Readiness())
+	})
+	h.server = &http.Server{Handler: mux}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			h.log.Errorln("health: server failed:", err)
+		}
+	}(h.server)
+	h.log.Infoln("health: listening on", "http://"+listener.Addr().String())
+	return nil
+}
+
+func (h *HealthServer) Stop() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := h.server.Shutdown(ctx)
+	h.server = nil
+	return err
+}
+
+func (h *HealthServer) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
+func (h *HealthServer) SetupAdminHandlers(a *admin.AdminSocket)                                   {}
+
+func (h *HealthServer) IsStarted() bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.server != nil
+}
+
+func (h *HealthServer) Health() error {
+	if h.config.Enable && !h.IsStarted() {
+		return errors.New("server is not running")
+	}
+	return nil
+}
+
+// Liveness checks that the core responds, and that the admin socket, the TUN
+// adapter, if the node has one, and every Popura module are healthy.
+func (h *HealthServer) Liveness() *Report {
+	report := &Report{Checks: make(map[string]Check)}
+	report.add("core", h.checkCore())
+	if h.options.Admin != nil {
+		var err error
+		if !h.options.Admin.IsStarted() {
+			err = errors.New("admin socket is not running")
+		}
+		report.add("admin", err)
+	}
+	if h.tunEnabled {
+		var err error
+		if h.options.TUN == nil || !h.options.TUN.IsStarted() {
+			err = errors.New("TUN adapter is not running")
+		}
+		report.add("tun", err)
+	}
+	names := make([]string, 0, len(h.options.Modules))
+	for name := range h.options.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if module := h.options.Modules[name]; module != nil {
+			report.add(name, module.Health())
+		}
+	}
+	return report
+}
+
+// Readiness checks everything that Liveness does, and that the node has at
+// least MinPeers peers.
+func (h *HealthServer) Readiness() *Report {
+	report := h.Liveness()
+	if report.Checks["core"].OK {
+		var err error
+		if peers := len(h.core.GetPeers()); peers < h.config.MinPeers {
+			err = fmt.Errorf("%d peers connected, %d required", peers, h.config.MinPeers)
+		}
+		report.add("peers", err)
+	}
+	return report
+}
+
+// checkCore returns an error if the core doesn't respond in time.
+func (h *HealthServer) checkCore() error {
+	done := make(chan struct{})
+	go func() {
+		h.core.GetSelf()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(coreTimeout):
+		return errors.New("core is not responding")
+	}
+}
+
+func (r *Report) add(name string, err error) {
+	check := Check{OK: err == nil}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	r.Checks[name] = check
+}
+
+// Err returns an error naming the first check that failed, in order of name,
+// or nil if every check passed.
+func (r *Report) Err() error {
+	names := make([]string, 0, len(r.Checks))
+	for name := range r.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if check := r.Checks[name]; !check.OK {
+			return fmt.Errorf("%s: %s", name, check.Error)
+		}
+	}
+	return nil
+}
+
+func (h *HealthServer) serveReport(rw http.ResponseWriter, report *Report) {
+	status := http.StatusOK
+	report.Status = "ok"
+	for _, check := range report.Checks {
+		if !check.OK {
+			status = http.StatusServiceUnavailable
+			report.Status = "fail"
+			break
+		}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(report); err != nil {
+		h.log.Debugln("health: error writing response:", err)
+	}
+}
@@ -0,0 +1,87 @@
+package health
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+// module is a Popura module whose health is set by the test.
+type module struct {
+	popura.Module
+	err error
+}
+
+func (m *module) Health() error { return m.err }
+
+func TestHealth(t *testing.T) {
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logging.New(io.Discard, "text").Logger("health")
+	c, err := core.New(sk, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	healthy, failing := &module{}, &module{}
+	h := &HealthServer{}
+	options := Options{
+		Modules: map[string]popura.Module{"healthy": healthy, "failing": failing},
+	}
+	if err := h.Init(c, &config.NodeConfig{IfName: "none"}, popura.GenerateConfig(), log, options); err != nil {
+		t.Fatal(err)
+	}
+	h.config.MinPeers = 0
+
+	tests := []struct {
+		name   string
+		fail   error
+		probe  func() *Report
+		status int
+		checks map[string]bool
+	}{
+		{"live", nil, h.Liveness, http.StatusOK, map[string]bool{"core": true, "healthy": true, "failing": true}},
+		{"not live", errors.New("stopped"), h.Liveness, http.StatusServiceUnavailable, map[string]bool{"core": true, "healthy": true, "failing": false}},
+		{"ready", nil, h.Readiness, http.StatusOK, map[string]bool{"core": true, "healthy": true, "failing": true, "peers": true}},
+		{"not ready", errors.New("stopped"), h.Readiness, http.StatusServiceUnavailable, map[string]bool{"core": true, "healthy": true, "failing": false, "peers": true}},
+	}
+	for _, test := range tests {
+		failing.err = test.fail
+		rec := httptest.NewRecorder()
+		h.serveReport(rec, test.probe())
+		if rec.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, test.status)
+		}
+		var report Report
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if (report.Status == "ok") != (test.status == http.StatusOK) {
+			t.Errorf("%s: got status %q", test.name, report.Status)
+		}
+		if len(report.Checks) != len(test.checks) {
+			t.Errorf("%s: got checks %v", test.name, report.Checks)
+		}
+		for name, ok := range test.checks {
+			if check := report.Checks[name]; check.OK != ok || (check.Error == "") != ok {
+				t.Errorf("%s: got %s check %+v", test.name, name, check)
+			}
+		}
+		if err := test.probe().Err(); (err == nil) != (test.fail == nil) {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+}
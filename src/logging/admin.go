@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"encoding/json"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+type GetLogLevelsRequest struct{}
+type GetLogLevelsResponse struct {
+	Levels map[string]string `json:"levels"`
+}
+
+type SetLogLevelRequest struct {
+	Module string `json:"module"`
+	Level  string `json:"level"`
+}
+type SetLogLevelResponse struct {
+	Levels map[string]string `json:"levels"`
+}
+
+func (l *Logging) getLogLevelsHandler(req *GetLogLevelsRequest, res *GetLogLevelsResponse) error {
+	res.Levels = l.GetLevels()
+	return nil
+}
+
+func (l *Logging) setLogLevelHandler(req *SetLogLevelRequest, res *SetLogLevelResponse) error {
+	if err := l.SetLevel(req.Module, req.Level); err != nil {
+		return err
+	}
+	res.Levels = l.GetLevels()
+	return nil
+}
+
+func (l *Logging) SetupAdminHandlers(a *admin.AdminSocket) {
+	_ = a.AddHandler(
+		"getLogLevels", "Show the log level of each module", []string{},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &GetLogLevelsRequest{}
+			res := &GetLogLevelsResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := l.getLogLevelsHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"setLogLevel", "Set the log level of a module, or of all modules if none is given", []string{"level", "[module]"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &SetLogLevelRequest{}
+			res := &SetLogLevelResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := l.setLogLevelHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+}
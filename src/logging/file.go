@@ -0,0 +1,164 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File is a log file that is rotated once it grows larger than its maximum
+// size or is older than its maximum age. The time that the file was started
+// is recorded in path.opened, so that restarting the node doesn't postpone
+// its rotation; the age of a file without a record is counted from when it is
+// opened. Rotated files are renamed to path.1, path.2 and so on, with path.1
+// being the most recent, and only the given number of them is kept.
+type File struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	backups int
+	mutex   sync.Mutex
+	file    *os.File
+	size    int64
+	opened  time.Time // start of the file's age
+	closed  bool
+}
+
+// OpenFile opens the log file at path, appending to it if it exists. A
+// maxSize in bytes or maxAge of zero disables rotation by size or age.
+func OpenFile(path string, maxSize int64, maxAge time.Duration, backups int) (*File, error) {
+	f := &File{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		backups: backups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	now := time.Now()
+	if f.size > 0 {
+		if opened, err := f.readOpened(); err == nil && !opened.After(now) {
+			f.opened = opened
+			return nil
+		}
+	}
+	f.opened = now
+	// Without the record the file is only rotated by age later than it
+	// should be, so failing to write it isn't an error.
+	_ = os.WriteFile(f.openedPath(), []byte(now.UTC().Format(time.RFC3339)+"\n"), 0644)
+	return nil
+}
+
+// openedPath is the path of the file that records when the log file was
+// started.
+func (f *File) openedPath() string {
+	return f.path + ".opened"
+}
+
+func (f *File) readOpened() (time.Time, error) {
+	data, err := os.ReadFile(f.openedPath())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.file != nil && f.size > 0 {
+		tooLarge := f.maxSize > 0 && f.size+int64(len(p)) > f.maxSize
+		tooOld := f.maxAge > 0 && time.Since(f.opened) > f.maxAge
+		if tooLarge || tooOld {
+			if err := f.rotate(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	// If opening the file failed before, try again.
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate closes the file, shifts the rotated files along and opens a new file.
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if f.backups > 0 {
+		_ = os.Remove(f.backup(f.backups))
+		for i := f.backups - 1; i > 0; i-- {
+			if err := os.Rename(f.backup(i), f.backup(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(f.path, f.backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *File) backup(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+// Reopen closes the file and opens it again by its path. This is used when
+// the file was moved away by an external tool such as logrotate.
+func (f *File) Reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			return err
+		}
+		f.file = nil
+	}
+	return f.open()
+}
+
+// Close closes the file. Later writes fail.
+func (f *File) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int64
+		maxAge  time.Duration
+		backups int
+		age     time.Duration // of the existing file, or -1 if it isn't recorded
+		writes  []string
+		want    []string // contents of the file and its backups
+	}{
+		{"no rotation", 0, 0, 2, 0, []string{"a", "b", "c"}, []string{"abc"}},
+		{"size", 2, 0, 2, 0, []string{"a", "b", "c"}, []string{"c", "ab"}},
+		{"size backups", 1, 0, 2, 0, []string{"a", "b", "c", "d"}, []string{"d", "c", "b"}},
+		{"size no backups", 1, 0, 0, 0, []string{"a", "b", "c"}, []string{"c"}},
+		{"large write", 2, 0, 2, 0, []string{"abc", "d"}, []string{"d", "abc"}},
+		{"age", 0, time.Hour, 2, 2 * time.Hour, []string{"a", "b"}, []string{"ab", "x"}},
+		{"age not reached", 0, time.Hour, 2, time.Minute, []string{"a", "b"}, []string{"xab"}},
+		{"age unknown", 0, time.Hour, 2, -1, []string{"a", "b"}, []string{"xab"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "popura.log")
+			if test.age != 0 {
+				if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				// The modification time doesn't count.
+				mtime := time.Now().Add(-2 * test.maxAge)
+				if err := os.Chtimes(path, mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.age > 0 {
+				opened := time.Now().Add(-test.age).UTC().Format(time.RFC3339)
+				if err := os.WriteFile(path+".opened", []byte(opened), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f, err := OpenFile(path, test.maxSize, test.maxAge, test.backups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			for _, w := range test.writes {
+				if _, err := f.Write([]byte(w)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got []string
+			for i := 0; ; i++ {
+				name := path
+				if i > 0 {
+					name = f.backup(i)
+				}
+				data, err := os.ReadFile(name)
+				if os.IsNotExist(err) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(data))
+			}
+			if strings.Join(got, "|") != strings.Join(test.want, "|") {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFileOpened(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "popura.log")
+	f, err := OpenFile(path, 0, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	opened, err := f.readOpened()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(opened); d < 0 || d > time.Minute {
+		t.Errorf("recorded %s as the start of the file", opened)
+	}
+
+	// Opening the file again keeps its start.
+	earlier := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	if err := os.WriteFile(path+".opened", []byte(earlier.Format(time.RFC3339)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = OpenFile(path, 0, time.Hour, 2); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if !f.opened.Equal(earlier) {
+		t.Errorf("got start %s, want %s", f.opened, earlier)
+	}
+}
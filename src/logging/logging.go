@@ -0,0 +1,280 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gologme/log"
+)
+
+// Every module of the node gets its own logger, so that the level of each can
+// be set separately. Levels are checked by Logger before a message is
+// formatted, rather than with log.Logger.EnableLevel, which isn't safe to call
+// while the node is running. Logger implements core.Logger, so it is given to
+// the core, admin socket and TUN adapter as it is; only multicast takes a
+// *log.Logger.
+
+// Levels are the log levels in order of verbosity.
+var Levels = []string{"error", "warn", "info", "debug", "trace"}
+
+// Modules are the parts of the node that have their own logger.
+var Modules = []string{"main", "core", "admin", "multicast", "tun", "meshname", "autopeering", "metrics", "api", "health", "events", "hooks", "accounting", "shaping", "firewall"}
+
+const logPackage = "github.com/gologme/log"
+
+// Logging creates the loggers of the modules, which all write to the same
+// output in the same format.
+type Logging struct {
+	mutex  sync.Mutex // serialises writes to out
+	out    io.Writer
+	format string
+	levels map[string]*int32 // index into Levels, keyed by module
+}
+
+// New returns a Logging that writes to out in the given format, either "text"
+// or "json". All modules log at the info level.
+func New(out io.Writer, format string) *Logging {
+	l := &Logging{
+		out:    out,
+		format: format,
+		levels: make(map[string]*int32),
+	}
+	for _, module := range Modules {
+		level := int32(levelIndex("info"))
+		l.levels[module] = &level
+	}
+	return l
+}
+
+// Logger returns a new logger for module, which must be one of Modules.
+func (l *Logging) Logger(module string) *Logger {
+	logger := log.New(&moduleWriter{l, module}, "", 0)
+	for _, level := range Levels {
+		logger.EnableLevel(level)
+	}
+	return &Logger{
+		Logger:  logger,
+		logging: l,
+		module:  module,
+		level:   l.levels[module],
+	}
+}
+
+// write writes a message of module at level. In text format, the message is
+// preceded by the time and the module's name.
+func (l *Logging) write(module, level, message string) error {
+	message = strings.TrimRight(message, "\n")
+	// Popura modules already prefix their messages with their name.
+	message = strings.TrimPrefix(message, module+": ")
+	var out []byte
+	if l.format == "json" {
+		bs, err := json.Marshal(logEntry{
+			Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+			Level:     level,
+			Module:    module,
+			Message:   message,
+		})
+		if err != nil {
+			return err
+		}
+		out = append(bs, '\n')
+	} else {
+		out = []byte(time.Now().Format("2006/01/02 15:04:05 ") + module + ": " + message + "\n")
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err := l.out.Write(out)
+	return err
+}
+
+// SetLevel sets the level of module, or of all modules if module is empty.
+func (l *Logging) SetLevel(module, level string) error {
+	index := levelIndex(strings.ToLower(level))
+	if index < 0 {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	if module == "" {
+		for _, p := range l.levels {
+			atomic.StoreInt32(p, int32(index))
+		}
+		return nil
+	}
+	p, ok := l.levels[module]
+	if !ok {
+		return fmt.Errorf("unknown module %q", module)
+	}
+	atomic.StoreInt32(p, int32(index))
+	return nil
+}
+
+// GetLevels returns the level of each module.
+func (l *Logging) GetLevels() map[string]string {
+	levels := make(map[string]string, len(l.levels))
+	for module, p := range l.levels {
+		levels[module] = Levels[atomic.LoadInt32(p)]
+	}
+	return levels
+}
+
+// ParseLevels parses a list of log levels such as "info,core=warn", as given
+// to the -loglevel option. It returns the level given for all modules, if
+// any, and the levels given for single modules.
+func ParseLevels(spec string) (string, map[string]string, error) {
+	var all string
+	modules := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		module, level := "", item
+		if i := strings.IndexByte(item, '='); i >= 0 {
+			module, level = item[:i], item[i+1:]
+		}
+		if levelIndex(level) < 0 {
+			return "", nil, fmt.Errorf("unknown log level %q", level)
+		}
+		if module == "" {
+			all = level
+			continue
+		}
+		if !isModule(module) {
+			return "", nil, fmt.Errorf("unknown module %q", module)
+		}
+		modules[module] = level
+	}
+	return all, modules, nil
+}
+
+func levelIndex(level string) int {
+	for i, l := range Levels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+func isModule(module string) bool {
+	for _, m := range Modules {
+		if m == module {
+			return true
+		}
+	}
+	return false
+}
+
+// Logger is the logger of a module. Messages above the module's level are
+// dropped before they are formatted. The embedded log.Logger is for multicast,
+// which takes a *log.Logger. Its writer has to find the level of each message
+// on the call stack after it has been formatted, so everything else uses
+// Logger's methods instead.
+type Logger struct {
+	*log.Logger
+	logging *Logging
+	module  string
+	level   *int32
+}
+
+const (
+	levelError = iota
+	levelWarn
+	levelInfo
+	levelDebug
+	levelTrace
+)
+
+func (l *Logger) enabled(level int) bool {
+	return level <= int(atomic.LoadInt32(l.level))
+}
+
+func (l *Logger) print(level int, sprint func(...interface{}) string, v []interface{}) {
+	if l.enabled(level) {
+		_ = l.logging.write(l.module, Levels[level], sprint(v...))
+	}
+}
+
+func (l *Logger) printf(level int, format string, v []interface{}) {
+	if l.enabled(level) {
+		_ = l.logging.write(l.module, Levels[level], fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger) Error(v ...interface{})                 { l.print(levelError, fmt.Sprint, v) }
+func (l *Logger) Errorf(format string, v ...interface{}) { l.printf(levelError, format, v) }
+func (l *Logger) Errorln(v ...interface{})               { l.print(levelError, fmt.Sprintln, v) }
+func (l *Logger) Warn(v ...interface{})                  { l.print(levelWarn, fmt.Sprint, v) }
+func (l *Logger) Warnf(format string, v ...interface{})  { l.printf(levelWarn, format, v) }
+func (l *Logger) Warnln(v ...interface{})                { l.print(levelWarn, fmt.Sprintln, v) }
+func (l *Logger) Info(v ...interface{})                  { l.print(levelInfo, fmt.Sprint, v) }
+func (l *Logger) Infof(format string, v ...interface{})  { l.printf(levelInfo, format, v) }
+func (l *Logger) Infoln(v ...interface{})                { l.print(levelInfo, fmt.Sprintln, v) }
+func (l *Logger) Debug(v ...interface{})                 { l.print(levelDebug, fmt.Sprint, v) }
+func (l *Logger) Debugf(format string, v ...interface{}) { l.printf(levelDebug, format, v) }
+func (l *Logger) Debugln(v ...interface{})               { l.print(levelDebug, fmt.Sprintln, v) }
+func (l *Logger) Trace(v ...interface{})                 { l.print(levelTrace, fmt.Sprint, v) }
+func (l *Logger) Tracef(format string, v ...interface{}) { l.printf(levelTrace, format, v) }
+func (l *Logger) Traceln(v ...interface{})               { l.print(levelTrace, fmt.Sprintln, v) }
+
+// Messages logged without a level count as info.
+func (l *Logger) Print(v ...interface{})                 { l.print(levelInfo, fmt.Sprint, v) }
+func (l *Logger) Printf(format string, v ...interface{}) { l.printf(levelInfo, format, v) }
+func (l *Logger) Println(v ...interface{})               { l.print(levelInfo, fmt.Sprintln, v) }
+
+// moduleWriter writes the output of the embedded log.Logger of a module. The
+// level of each message is found on the call stack, and messages above the
+// module's level are dropped.
+type moduleWriter struct {
+	logging *Logging
+	module  string
+}
+
+func (w *moduleWriter) Write(p []byte) (int, error) {
+	level := callerLevel()
+	if levelIndex(level) > int(atomic.LoadInt32(w.logging.levels[w.module])) {
+		return len(p), nil
+	}
+	if err := w.logging.write(w.module, level, string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// logEntry is a single line of JSON log output.
+type logEntry struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Module    string `json:"module"`
+	Message   string `json:"message"`
+}
+
+// callerLevel returns the level of the message being logged. The logger
+// doesn't pass it on, so it is taken from the name of the logging method that
+// was called, e.g. Infoln. Messages logged without a level count as info.
+func callerLevel() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, logPackage+".") {
+			fn := frame.Function[strings.LastIndexByte(frame.Function, '.')+1:]
+			for _, level := range []string{"Error", "Warn", "Info", "Debug", "Trace"} {
+				if strings.HasPrefix(fn, level) {
+					return strings.ToLower(level)
+				}
+			}
+			if strings.HasPrefix(fn, "Fatal") || strings.HasPrefix(fn, "Panic") {
+				return "error"
+			}
+		}
+		if !more {
+			return "info"
+		}
+	}
+}
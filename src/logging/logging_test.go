@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// formatted counts how often it is formatted.
+type formatted int
+
+func (f *formatted) String() string {
+	*f++
+	return "message"
+}
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	logs := New(&out, "text")
+	if err := logs.SetLevel("core", "warn"); err != nil {
+		t.Fatal(err)
+	}
+	log := logs.Logger("core")
+	var f formatted
+	log.Debugln(&f)
+	log.Infof("%s", &f)
+	if f != 0 || out.Len() != 0 {
+		t.Errorf("a filtered message was formatted %d times and written as %q", f, out.String())
+	}
+	log.Warnln(&f)
+	if line := out.String(); !strings.HasSuffix(line, " core: message\n") {
+		t.Errorf("got %q", line)
+	}
+	out.Reset()
+	log.Logger.Infoln("through multicast's logger")
+	log.Logger.Warnln("core: through multicast's logger")
+	if line := out.String(); strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, " core: through multicast's logger\n") {
+		t.Errorf("got %q", line)
+	}
+
+	out.Reset()
+	logs = New(&out, "json")
+	logs.Logger("meshname").Errorf("meshname: %s", "failed")
+	var entry logEntry
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Level != "error" || entry.Module != "meshname" || entry.Message != "failed" {
+		t.Errorf("got %+v", entry)
+	}
+}
@@ -0,0 +1,200 @@
+package meshname
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+
+	_meshname "github.com/zhoreeq/meshname/pkg/meshname"
+)
+
+type GetMeshnameRequest struct{}
+type GetMeshnameResponse struct {
+	Enabled     bool     `json:"enabled"`
+	Started     bool     `json:"started"`
+	Listen      string   `json:"listen,omitempty"`
+	TLSListen   string   `json:"tls_listen,omitempty"`
+	HTTPSListen string   `json:"https_listen,omitempty"`
+	ZoneListen  string   `json:"zone_listen,omitempty"`
+	Zone        []string `json:"zone,omitempty"`
+	Queries     uint64   `json:"queries"`
+	Errors      uint64   `json:"errors"`
+	RateLimited uint64   `json:"rate_limited"`
+	Clients     int      `json:"clients,omitempty"`
+}
+
+type MeshnameResolveRequest struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+type MeshnameResolveResponse struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Rcode   string   `json:"rcode"`
+	Records []string `json:"records"`
+}
+
+type MeshnameEncodeRequest struct {
+	IP string `json:"ip"`
+}
+type MeshnameEncodeResponse struct {
+	IP   string `json:"ip"`
+	Name string `json:"name"`
+}
+
+type MeshnameDecodeRequest struct {
+	Name string `json:"name"`
+}
+type MeshnameDecodeResponse struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+func (s *MeshnameServer) getMeshnameHandler(req *GetMeshnameRequest, res *GetMeshnameResponse) error {
+	res.Enabled = s.enable
+	res.Started = s.IsStarted()
+	res.Queries = atomic.LoadUint64(&s.queries)
+	res.Errors = atomic.LoadUint64(&s.errors)
+	res.RateLimited = atomic.LoadUint64(&s.ratelimited)
+	if s.limiter != nil {
+		res.Clients = s.limiter.clients()
+	}
+	if !s.enable {
+		return nil
+	}
+	res.Listen = s.config.Listen
+	res.TLSListen = s.config.TLSListen
+	res.HTTPSListen = s.config.HTTPSListen
+	if s.zone != nil {
+		res.ZoneListen = net.JoinHostPort(s.core.Address().String(), "53")
+		res.Zone = s.zone.origins
+	}
+	return nil
+}
+
+func (s *MeshnameServer) meshnameResolveHandler(req *MeshnameResolveRequest, res *MeshnameResolveResponse) error {
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	if req.Type == "" {
+		req.Type = "AAAA"
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(req.Type)]
+	if !ok {
+		return fmt.Errorf("unknown record type %q", req.Type)
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(req.Name), qtype)
+	rw := &responseWriter{local: &net.UDPAddr{}, remote: &net.UDPAddr{}}
+	s.mux.ServeDNS(rw, m)
+	if rw.msg == nil {
+		return errors.New("no response")
+	}
+	res.Name = m.Question[0].Name
+	res.Type = dns.TypeToString[qtype]
+	res.Rcode = dns.RcodeToString[rw.msg.Rcode]
+	res.Records = []string{}
+	for _, rr := range rw.msg.Answer {
+		res.Records = append(res.Records, rr.String())
+	}
+	return nil
+}
+
+func (s *MeshnameServer) meshnameEncodeHandler(req *MeshnameEncodeRequest, res *MeshnameEncodeResponse) error {
+	ip := net.ParseIP(req.IP)
+	if ip == nil || ip.To4() != nil {
+		return fmt.Errorf("invalid IPv6 address %q", req.IP)
+	}
+	res.IP = ip.String()
+	res.Name = _meshname.DomainFromIP(&ip) + ".meshname"
+	return nil
+}
+
+func (s *MeshnameServer) meshnameDecodeHandler(req *MeshnameDecodeRequest, res *MeshnameDecodeResponse) error {
+	labels := dns.SplitDomainName(req.Name)
+	if len(labels) == 0 {
+		return errors.New("name is required")
+	}
+	// Accept either a bare label or a full name, i.e. "aiag...", or
+	// "aiag.meshname" or "www.aiag.meshname".
+	label := labels[0]
+	if len(labels) > 1 {
+		if _, ok := s.networks[labels[len(labels)-1]]; !ok {
+			return fmt.Errorf("unknown top-level domain in %q", req.Name)
+		}
+		label = labels[len(labels)-2]
+	}
+	ip, err := _meshname.IPFromDomain(&label)
+	if err != nil {
+		return fmt.Errorf("invalid meshname %q: %w", req.Name, err)
+	}
+	res.Name = req.Name
+	res.IP = ip.String()
+	return nil
+}
+
+func (s *MeshnameServer) SetupAdminHandlers(a *admin.AdminSocket) {
+	_ = a.AddHandler(
+		"getMeshname", "Show the status of the meshname DNS server", []string{},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &GetMeshnameRequest{}
+			res := &GetMeshnameResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := s.getMeshnameHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"meshnameResolve", "Resolve a meshname domain", []string{"name", "type"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &MeshnameResolveRequest{}
+			res := &MeshnameResolveResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := s.meshnameResolveHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"meshnameEncode", "Encode an IPv6 address as a meshname domain", []string{"ip"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &MeshnameEncodeRequest{}
+			res := &MeshnameEncodeResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := s.meshnameEncodeHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"meshnameDecode", "Decode a meshname domain into an IPv6 address", []string{"name"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &MeshnameDecodeRequest{}
+			res := &MeshnameDecodeResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := s.meshnameDecodeHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+}
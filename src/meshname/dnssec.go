@@ -0,0 +1,188 @@
+package meshname
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+)
+
+// Records of the node's own zone are signed with the node's ed25519 key using
+// DNSSEC RRSIG records. As Yggdrasil addresses are derived from public keys,
+// a resolver can check that the DNSKEY served for a meshname domain is the
+// one that owns the address encoded in the domain, without any chain of
+// trust.
+
+const (
+	signatureInception  = time.Hour
+	signatureExpiration = 7 * 24 * time.Hour
+	dnssecUDPSize       = 4096
+)
+
+// dnskeyFor returns the DNSKEY record that publishes key at origin.
+func dnskeyFor(origin string, key ed25519.PublicKey) *dns.DNSKEY {
+	return &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: origin, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: defaultTTL},
+		Flags:     257, // zone key, secure entry point
+		Protocol:  3,
+		Algorithm: dns.ED25519,
+		PublicKey: base64.StdEncoding.EncodeToString(key),
+	}
+}
+
+// rrsets splits records into sets sharing the same owner name and type,
+// skipping any existing signatures.
+func rrsets(records []dns.RR) [][]dns.RR {
+	var sets [][]dns.RR
+	index := make(map[string]int)
+	for _, rr := range records {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		id := dns.CanonicalName(rr.Header().Name) + "/" + dns.TypeToString[rr.Header().Rrtype]
+		if i, ok := index[id]; ok {
+			sets[i] = append(sets[i], rr)
+			continue
+		}
+		index[id] = len(sets)
+		sets = append(sets, []dns.RR{rr})
+	}
+	return sets
+}
+
+// sign returns RRSIG records covering every record set in records, signed by
+// the DNSKEY of the origin that each set belongs to.
+func (z *zone) sign(records []dns.RR, now time.Time) ([]dns.RR, error) {
+	if z.key == nil {
+		return nil, errors.New("zone has no signing key")
+	}
+	var sigs []dns.RR
+	for _, set := range rrsets(records) {
+		origin := z.originFor(set[0].Header().Name)
+		if origin == "" {
+			continue
+		}
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Name: set[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: set[0].Header().Ttl},
+			Algorithm:  dns.ED25519,
+			SignerName: origin,
+			KeyTag:     z.keys[origin].KeyTag(),
+			Inception:  uint32(now.Add(-signatureInception).Unix()),
+			Expiration: uint32(now.Add(signatureExpiration).Unix()),
+		}
+		if err := sig.Sign(z.key, set); err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// ownerKeys returns the ed25519 DNSKEY records in keys that own the Yggdrasil
+// address target, by key tag.
+func ownerKeys(target net.IP, keys []dns.RR) map[uint16]*dns.DNSKEY {
+	owners := make(map[uint16]*dns.DNSKEY)
+	for _, rr := range keys {
+		key, ok := rr.(*dns.DNSKEY)
+		if !ok || key.Algorithm != dns.ED25519 {
+			continue
+		}
+		pub, err := base64.StdEncoding.DecodeString(key.PublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			continue
+		}
+		addr := address.AddrForKey(ed25519.PublicKey(pub))
+		if addr != nil && net.IP(addr[:]).Equal(target) {
+			owners[key.KeyTag()] = key
+		}
+	}
+	return owners
+}
+
+// verifyAnswer checks that every record set in resp is signed by a key in
+// keys that owns the Yggdrasil address target, and that every question that
+// isn't answered is denied by a signed NSEC record owned by the name asked
+// for. Unsigned records, signatures by other keys, expired signatures and
+// unproven denials are all rejected.
+func verifyAnswer(target net.IP, keys []dns.RR, resp *dns.Msg, now time.Time) error {
+	owners := ownerKeys(target, keys)
+	if len(owners) == 0 {
+		return fmt.Errorf("no DNSKEY matching %s", target)
+	}
+
+	records := append(append([]dns.RR{}, resp.Answer...), resp.Ns...)
+	for _, set := range rrsets(records) {
+		verified := false
+		for _, rr := range records {
+			sig, ok := rr.(*dns.RRSIG)
+			if !ok || sig.TypeCovered != set[0].Header().Rrtype ||
+				dns.CanonicalName(sig.Header().Name) != dns.CanonicalName(set[0].Header().Name) {
+				continue
+			}
+			key, ok := owners[sig.KeyTag]
+			if !ok || !sig.ValidityPeriod(now) {
+				continue
+			}
+			if err := sig.Verify(key, set); err == nil {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return fmt.Errorf("no valid signature for %s %s", set[0].Header().Name, dns.TypeToString[set[0].Header().Rrtype])
+		}
+	}
+	for _, q := range resp.Question {
+		if resp.Rcode == dns.RcodeSuccess && answers(resp.Answer, q) {
+			continue
+		}
+		if !denies(resp.Ns, q) {
+			return fmt.Errorf("no signed NSEC denying %s %s", q.Name, dns.TypeToString[q.Qtype])
+		}
+	}
+	return nil
+}
+
+// answers reports whether records hold an answer to q.
+func answers(records []dns.RR, q dns.Question) bool {
+	for _, rr := range records {
+		h := rr.Header()
+		if dns.CanonicalName(h.Name) == dns.CanonicalName(q.Name) &&
+			(h.Rrtype == q.Qtype || h.Rrtype == dns.TypeCNAME || q.Qtype == dns.TypeANY) {
+			return true
+		}
+	}
+	return false
+}
+
+// denies reports whether records hold an NSEC record owned by the name of q
+// whose type bitmap lacks the type of q, and whose signature has already been
+// verified. Only an NSEC record listing no types at all denies an ANY query.
+func denies(records []dns.RR, q dns.Question) bool {
+	for _, rr := range records {
+		nsec, ok := rr.(*dns.NSEC)
+		if !ok || dns.CanonicalName(nsec.Hdr.Name) != dns.CanonicalName(q.Name) {
+			continue
+		}
+		denied := true
+		for _, t := range nsec.TypeBitMap {
+			switch t {
+			case dns.TypeRRSIG, dns.TypeNSEC, typeNXNAME:
+			default:
+				if q.Qtype == dns.TypeANY || t == q.Qtype || t == dns.TypeCNAME {
+					denied = false
+				}
+			}
+		}
+		if denied {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package meshname
+
+import (
+	"crypto/ed25519"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+)
+
+func testZone(t *testing.T, addr net.IP) (*zone, net.IP) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr == nil {
+		a := address.AddrForKey(pub)
+		addr = net.IP(a[:])
+	}
+	z, err := newZone(addr, []string{"meshname"}, nil, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z, addr
+}
+
+func TestVerifyAnswer(t *testing.T) {
+	now := time.Now()
+	z, target := testZone(t, nil)
+	origin := z.origins[0]
+	other, _ := testZone(t, target) // same domain, another key
+	stranger, _ := testZone(t, nil) // another domain and key
+
+	signed := func(z *zone, records []dns.RR, at time.Time) []dns.RR {
+		sigs, err := z.sign(records, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(append([]dns.RR{}, records...), sigs...)
+	}
+	aaaa, _ := z.lookup(origin, dns.TypeAAAA)
+	soa := []dns.RR{z.soas[origin]}
+	nodata := []dns.RR{z.soas[origin], z.denial(origin)}
+	missing := "missing." + origin
+	nxname := []dns.RR{z.soas[origin], z.denial(missing)}
+	withType := []dns.RR{z.soas[origin], z.denial(origin)}
+	withType[1].(*dns.NSEC).TypeBitMap = []uint16{dns.TypeTXT, dns.TypeRRSIG, dns.TypeNSEC}
+	keys := []dns.RR{z.keys[origin]}
+
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		keys   []dns.RR
+		rcode  int
+		answer []dns.RR
+		ns     []dns.RR
+		ok     bool
+	}{
+		{"signed", origin, dns.TypeAAAA, keys, dns.RcodeSuccess, signed(z, aaaa, now), nil, true},
+		{"unsigned", origin, dns.TypeAAAA, keys, dns.RcodeSuccess, aaaa, nil, false},
+		{"wrong key", origin, dns.TypeAAAA, keys, dns.RcodeSuccess, signed(other, aaaa, now), nil, false},
+		{"key of another address", origin, dns.TypeAAAA, []dns.RR{stranger.keys[stranger.origins[0]]}, dns.RcodeSuccess, aaaa, nil, false},
+		{"expired", origin, dns.TypeAAAA, keys, dns.RcodeSuccess, signed(z, aaaa, now.Add(-30*24*time.Hour)), nil, false},
+		{"empty NXDOMAIN", origin, dns.TypeAAAA, keys, dns.RcodeNameError, nil, nil, false},
+		{"empty NODATA", origin, dns.TypeAAAA, keys, dns.RcodeSuccess, nil, nil, false},
+		{"unsigned SOA", origin, dns.TypeAAAA, keys, dns.RcodeNameError, nil, soa, false},
+		{"signed SOA", origin, dns.TypeAAAA, keys, dns.RcodeNameError, nil, signed(z, soa, now), false},
+		{"unsigned NSEC", origin, dns.TypeTXT, keys, dns.RcodeSuccess, nil, nodata, false},
+		{"signed NODATA", origin, dns.TypeTXT, keys, dns.RcodeSuccess, nil, signed(z, nodata, now), true},
+		{"NODATA for a type that exists", origin, dns.TypeAAAA, keys, dns.RcodeSuccess, nil, signed(z, nodata, now), false},
+		{"NODATA for ANY", origin, dns.TypeANY, keys, dns.RcodeSuccess, nil, signed(z, nodata, now), false},
+		{"NSEC listing the type", origin, dns.TypeTXT, keys, dns.RcodeSuccess, nil, signed(z, withType, now), false},
+		{"signed NXNAME", missing, dns.TypeAAAA, keys, dns.RcodeSuccess, nil, signed(z, nxname, now), true},
+		{"NXNAME for ANY", missing, dns.TypeANY, keys, dns.RcodeSuccess, nil, signed(z, nxname, now), true},
+		{"NSEC of another name", "other." + origin, dns.TypeAAAA, keys, dns.RcodeSuccess, nil, signed(z, nxname, now), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := new(dns.Msg)
+			resp.SetQuestion(test.qname, test.qtype)
+			resp.Response = true
+			resp.Rcode = test.rcode
+			resp.Answer = test.answer
+			resp.Ns = test.ns
+			err := verifyAnswer(target, test.keys, resp, now)
+			if test.ok && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if !test.ok && err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestDenial(t *testing.T) {
+	z, _ := testZone(t, nil)
+	origin := z.origins[0]
+	tests := []struct {
+		name   string
+		bitmap []uint16
+	}{
+		{origin, []uint16{dns.TypeSOA, dns.TypeAAAA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY}},
+		{"missing." + origin, []uint16{dns.TypeRRSIG, dns.TypeNSEC, typeNXNAME}},
+	}
+	for _, test := range tests {
+		nsec := z.denial(test.name).(*dns.NSEC)
+		if nsec.Hdr.Name != test.name || nsec.NextDomain != `\000.`+test.name {
+			t.Errorf("%s: got owner %s and next %s", test.name, nsec.Hdr.Name, nsec.NextDomain)
+		}
+		if !reflect.DeepEqual(nsec.TypeBitMap, test.bitmap) {
+			t.Errorf("%s: got types %v, want %v", test.name, nsec.TypeBitMap, test.bitmap)
+		}
+		if _, err := dns.PackRR(nsec, make([]byte, dns.Len(nsec)), 0, nil, false); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
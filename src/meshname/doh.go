@@ -0,0 +1,76 @@
+package meshname
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dohPath        = "/dns-query"
+	dohContentType = "application/dns-message"
+)
+
+// dohHandler serves DNS-over-HTTPS queries as described in RFC 8484 by
+// passing them to the same handlers as the plain DNS listeners.
+type dohHandler struct {
+	mux dns.Handler
+}
+
+func (h *dohHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		buf, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		buf, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil || len(buf) == 0 {
+		http.Error(w, "malformed DNS query", http.StatusBadRequest)
+		return
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(buf); err != nil {
+		http.Error(w, "malformed DNS query", http.StatusBadRequest)
+		return
+	}
+
+	rw := &responseWriter{local: localAddr(r), remote: remoteAddr(r)}
+	h.mux.ServeDNS(rw, req)
+	if rw.msg == nil {
+		http.Error(w, "no response", http.StatusInternalServerError)
+		return
+	}
+	res, err := rw.msg.Pack()
+	if err != nil {
+		http.Error(w, "malformed DNS response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", dohContentType)
+	_, _ = w.Write(res)
+}
+
+func localAddr(r *http.Request) net.Addr {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
+
+func remoteAddr(r *http.Request) net.Addr {
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
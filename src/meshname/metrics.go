@@ -0,0 +1,18 @@
+package meshname
+
+import (
+	"sync/atomic"
+
+	"github.com/popura-network/Popura/src/metrics"
+)
+
+func (s *MeshnameServer) WriteMetrics(w *metrics.Writer) {
+	w.Bool("popura_meshname_enabled", "Whether the meshname resolver is enabled.", s.enable)
+	w.Bool("popura_meshname_started", "Whether the meshname resolver is running.", s.IsStarted())
+	w.Counter("popura_meshname_queries_total", "DNS queries received.", float64(atomic.LoadUint64(&s.queries)))
+	w.Counter("popura_meshname_errors_total", "DNS queries that failed to resolve or be answered.", float64(atomic.LoadUint64(&s.errors)))
+	w.Counter("popura_meshname_rate_limited_total", "DNS queries refused because of rate limiting.", float64(atomic.LoadUint64(&s.ratelimited)))
+	if s.limiter != nil {
+		w.Gauge("popura_meshname_clients", "Clients currently tracked by the rate limiter.", float64(s.limiter.clients()))
+	}
+}
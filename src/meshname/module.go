@@ -1,56 +1,287 @@
 package meshname
 
 import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/gologme/log"
+	"github.com/miekg/dns"
 
-	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
 	"github.com/yggdrasil-network/yggdrasil-go/src/config"
 	"github.com/yggdrasil-network/yggdrasil-go/src/core"
 
-	_meshname "github.com/zhoreeq/meshname/pkg/meshname"
-
+	"github.com/popura-network/Popura/src/events"
+	"github.com/popura-network/Popura/src/logging"
 	"github.com/popura-network/Popura/src/popura"
 )
 
+const dnsTimeout = 5 * time.Second
+
 type MeshnameServer struct {
-	server *_meshname.MeshnameServer
-	log    *log.Logger
-	enable bool
+	queries     uint64 // atomic, must stay 64-bit aligned
+	errors      uint64 // atomic, must stay 64-bit aligned
+	ratelimited uint64 // atomic, must stay 64-bit aligned
+	core        *core.Core
+	log         *logging.Logger
+	bus         *events.Bus
+	config      popura.MeshnameConfig
+	privateKey  ed25519.PrivateKey
+	networks    map[string]*net.IPNet
+	client      *dns.Client
+	mux         *dns.ServeMux
+	handler     dns.Handler
+	zone        *zone
+	zoneMux     *dns.ServeMux
+	limiter     *rateLimiter
+	keyCache    map[string]*cachedKeys // DNSKEYs by remote server
+	keyLock     sync.Mutex
+	servers     []*dns.Server
+	https       *http.Server
+	enable      bool
+	started     bool
+	lock        sync.RWMutex
 }
 
-func (s *MeshnameServer) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *log.Logger, options interface{}) error {
+func (s *MeshnameServer) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
+	s.core = yggcore
 	s.log = log
+	s.bus, _ = options.(*events.Bus)
 	s.enable = popConfig.Meshname.Enable
+	s.config = popConfig.Meshname
+	if sk, err := hex.DecodeString(yggConfig.PrivateKey); err == nil && len(sk) == ed25519.PrivateKeySize {
+		s.privateKey = ed25519.PrivateKey(sk)
+	}
 	yggIPNet := &net.IPNet{IP: net.ParseIP("200::"), Mask: net.CIDRMask(7, 128)}
-	s.server = _meshname.New(
-		log,
-		popConfig.Meshname.Listen,
-		map[string]*net.IPNet{"ygg": yggIPNet, "meshname": yggIPNet, "popura": yggIPNet},
-		false, // enable meship protocol
-	)
+	s.networks = map[string]*net.IPNet{"ygg": yggIPNet, "meshname": yggIPNet, "popura": yggIPNet}
+	s.client = &dns.Client{Timeout: dnsTimeout}
+	s.keyCache = make(map[string]*cachedKeys)
+	if s.config.RateLimit > 0 {
+		s.limiter = newRateLimiter(s.config.RateLimit, s.config.RateBurst)
+	}
+	s.mux = dns.NewServeMux()
+	s.handler = s.instrument(s.mux)
+	tlds := make([]string, 0, len(s.networks))
+	for tld := range s.networks {
+		s.mux.HandleFunc(tld, s.handleMeshnameRequest)
+		tlds = append(tlds, tld)
+	}
+	sort.Strings(tlds)
+	if s.config.ListenYggdrasil {
+		var err error
+		if s.zone, err = newZone(s.core.Address(), tlds, s.config.Records, s.privateKey); err != nil {
+			return fmt.Errorf("invalid Records: %w", err)
+		}
+		s.zoneMux = dns.NewServeMux()
+		s.zoneMux.HandleFunc(".", s.handleZoneRequest)
+	}
 
 	return nil
 }
 
 func (s *MeshnameServer) Start() error {
-	if s.enable {
-		return s.server.Start()
+	if !s.enable {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started {
+		return errors.New("server is already started")
+	}
+
+	if err := s.listenUDP(s.config.Listen); err != nil {
+		s.stopListeners()
+		s.listenerFailed(s.config.Listen, err)
+		return err
+	}
+	if s.config.ListenYggdrasil {
+		if err := s.listenYggdrasil(); err != nil {
+			s.stopListeners()
+			s.listenerFailed(net.JoinHostPort(s.core.Address().String(), "53"), err)
+			return err
+		}
+	}
+	if s.config.TLSListen != "" || s.config.HTTPSListen != "" {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			s.stopListeners()
+			return err
+		}
+		if s.config.TLSListen != "" {
+			if err := s.listenTLS(s.config.TLSListen, tlsConfig); err != nil {
+				s.stopListeners()
+				s.listenerFailed(s.config.TLSListen, err)
+				return err
+			}
+		}
+		if s.config.HTTPSListen != "" {
+			if err := s.listenHTTPS(s.config.HTTPSListen, tlsConfig); err != nil {
+				s.stopListeners()
+				s.listenerFailed(s.config.HTTPSListen, err)
+				return err
+			}
+		}
+	}
+
+	s.started = true
+	s.log.Infoln("meshname: module started")
+	return nil
+}
+
+func (s *MeshnameServer) listenUDP(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	if err := s.serve(&dns.Server{PacketConn: conn, Handler: s.handler}); err != nil {
+		return err
+	}
+	s.log.Infoln("meshname: listening for DNS on", conn.LocalAddr())
+	return nil
+}
+
+func (s *MeshnameServer) listenTLS(addr string, tlsConfig *tls.Config) error {
+	listener, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		return err
+	}
+	if err := s.serve(&dns.Server{Listener: listener, Net: "tcp-tls", Handler: s.handler}); err != nil {
+		return err
+	}
+	s.log.Infoln("meshname: listening for DNS-over-TLS on", listener.Addr())
+	return nil
+}
+
+// listenYggdrasil serves the node's own zone on its Yggdrasil address. The
+// TUN adapter must already be up, otherwise the address can't be bound.
+func (s *MeshnameServer) listenYggdrasil() error {
+	addr := net.JoinHostPort(s.core.Address().String(), "53")
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("can't listen on the Yggdrasil address, is the TUN adapter enabled? %w", err)
+	}
+	if err := s.serve(&dns.Server{PacketConn: conn, Handler: s.instrument(s.zoneMux)}); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("can't listen on the Yggdrasil address: %w", err)
+	}
+	if err := s.serve(&dns.Server{Listener: listener, Handler: s.instrument(s.zoneMux)}); err != nil {
+		return err
+	}
+	s.log.Infoln("meshname: serving", s.zone.origins, "on", addr)
+	return nil
+}
+
+// serve runs the DNS server on its already bound socket and returns once it
+// is accepting queries, so that it can be shut down safely afterwards, or
+// with the error that stopped it from starting.
+func (s *MeshnameServer) serve(server *dns.Server) error {
+	started := make(chan struct{})
+	failed := make(chan error, 1)
+	server.NotifyStartedFunc = func() { close(started) }
+	var addr string
+	if server.PacketConn != nil {
+		addr = server.PacketConn.LocalAddr().String()
 	} else {
+		addr = server.Listener.Addr().String()
+	}
+	go func() {
+		err := server.ActivateAndServe()
+		select {
+		case <-started:
+			if err != nil {
+				s.log.Errorln("meshname: DNS server failed:", err)
+				s.listenerFailed(addr, err)
+			}
+		default:
+			if err == nil {
+				err = errors.New("DNS server stopped before it started")
+			}
+			failed <- err
+		}
+	}()
+	select {
+	case <-started:
+		s.servers = append(s.servers, server)
 		return nil
+	case err := <-failed:
+		if server.PacketConn != nil {
+			server.PacketConn.Close()
+		} else {
+			server.Listener.Close()
+		}
+		return err
+	}
+}
+
+// listenerFailed publishes the failure of the listener on addr.
+func (s *MeshnameServer) listenerFailed(addr string, err error) {
+	s.bus.Publish(events.MeshnameListenerFailed, map[string]string{
+		"listen": addr,
+		"error":  err.Error(),
+	})
+}
+
+func (s *MeshnameServer) listenHTTPS(addr string, tlsConfig *tls.Config) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(dohPath, &dohHandler{mux: s.handler})
+	s.https = &http.Server{Handler: mux, TLSConfig: tlsConfig}
+	go func(server *http.Server) {
+		if err := server.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+			s.log.Errorln("meshname: DNS-over-HTTPS server failed:", err)
+			s.listenerFailed(listener.Addr().String(), err)
+		}
+	}(s.https)
+	s.log.Infoln("meshname: listening for DNS-over-HTTPS on", listener.Addr())
+	return nil
+}
+
+func (s *MeshnameServer) stopListeners() {
+	for _, server := range s.servers {
+		if err := server.Shutdown(); err != nil {
+			s.log.Debugln("meshname: error stopping DNS server:", err)
+		}
+	}
+	s.servers = nil
+	if s.https != nil {
+		if err := s.https.Close(); err != nil {
+			s.log.Debugln("meshname: error stopping DNS-over-HTTPS server:", err)
+		}
+		s.https = nil
 	}
 }
 
 func (s *MeshnameServer) Stop() error {
-	s.server.Stop()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stopListeners()
+	s.started = false
 	return nil
 }
 
 func (s *MeshnameServer) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
 
-func (s *MeshnameServer) SetupAdminHandlers(a *admin.AdminSocket) {}
-
 func (s *MeshnameServer) IsStarted() bool {
-	return s.server.IsStarted()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.started
+}
+
+func (s *MeshnameServer) Health() error {
+	if s.enable && !s.IsStarted() {
+		return errors.New("server is not running")
+	}
+	return nil
 }
@@ -0,0 +1,69 @@
+package meshname
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// instrument wraps a DNS handler with per-client rate limiting and query
+// logging. It is used for every listener, but not for admin socket requests.
+func (s *MeshnameServer) instrument(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddUint64(&s.queries, 1)
+		start := time.Now()
+		client := clientIP(w.RemoteAddr())
+		lw := &loggingWriter{ResponseWriter: w, rcode: -1}
+		if s.limiter != nil && !s.limiter.allow(client, start) {
+			atomic.AddUint64(&s.ratelimited, 1)
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeRefused)
+			_ = lw.WriteMsg(m)
+		} else {
+			next.ServeDNS(lw, r)
+		}
+		s.logQuery(client, r, lw.rcode, time.Since(start))
+	})
+}
+
+func (s *MeshnameServer) logQuery(client string, r *dns.Msg, rcode int, latency time.Duration) {
+	names := make([]string, 0, len(r.Question))
+	types := make([]string, 0, len(r.Question))
+	for _, q := range r.Question {
+		names = append(names, q.Name)
+		types = append(types, dns.TypeToString[q.Qtype])
+	}
+	rcodeString := "NONE"
+	if rcode >= 0 {
+		rcodeString = dns.RcodeToString[rcode]
+	}
+	s.log.Debugf("meshname: query client=%s name=%s type=%s rcode=%s latency=%s",
+		client, strings.Join(names, ","), strings.Join(types, ","), rcodeString, latency)
+}
+
+func clientIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// loggingWriter records the response code written by a DNS handler.
+type loggingWriter struct {
+	dns.ResponseWriter
+	rcode int
+}
+
+func (w *loggingWriter) WriteMsg(m *dns.Msg) error {
+	w.rcode = m.Rcode
+	return w.ResponseWriter.WriteMsg(m)
+}
@@ -0,0 +1,79 @@
+package meshname
+
+import (
+	"sync"
+	"time"
+)
+
+const rateLimitPruneInterval = time.Minute
+
+// rateLimiter is a per-client token bucket rate limiter. Each client may send
+// up to burst queries at once, and the bucket refills at rate queries per
+// second.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst uint) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow reports whether a query from client may be served now, taking a token
+// from its bucket if so.
+func (l *rateLimiter) allow(client string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if now.Sub(l.lastPrune) > rateLimitPruneInterval {
+		l._prune(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// clients returns the number of clients currently being tracked.
+func (l *rateLimiter) clients() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.buckets)
+}
+
+// _prune forgets clients whose buckets would have refilled completely, as
+// they are indistinguishable from new clients.
+func (l *rateLimiter) _prune(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastPrune = now
+}
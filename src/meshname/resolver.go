@@ -0,0 +1,169 @@
+package meshname
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	_meshname "github.com/zhoreeq/meshname/pkg/meshname"
+)
+
+// handleMeshnameRequest resolves queries for meshname domains by forwarding
+// them to the authoritative DNS server listening on the address encoded in the
+// domain name itself.
+func (s *MeshnameServer) handleMeshnameRequest(w dns.ResponseWriter, r *dns.Msg) {
+	remoteLookups := make(map[string][]dns.Question)
+	remoteDomains := make(map[string]string)
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	for _, q := range r.Question {
+		labels := dns.SplitDomainName(q.Name)
+		if len(labels) < 2 {
+			s.log.Debugln("meshname: invalid domain requested:", q.Name)
+			atomic.AddUint64(&s.errors, 1)
+			continue
+		}
+		subDomain := labels[len(labels)-2]
+		resolvedAddr, err := _meshname.IPFromDomain(&subDomain)
+		if err != nil {
+			s.log.Debugln("meshname:", err)
+			atomic.AddUint64(&s.errors, 1)
+			continue
+		}
+		tld := labels[len(labels)-1]
+		if subnet, ok := s.networks[tld]; ok && subnet.Contains(resolvedAddr) {
+			remoteLookups[resolvedAddr.String()] = append(remoteLookups[resolvedAddr.String()], q)
+			remoteDomains[resolvedAddr.String()] = dns.Fqdn(subDomain + "." + tld)
+		} else {
+			s.log.Debugln("meshname: subnet doesn't match for", q.Name)
+			atomic.AddUint64(&s.errors, 1)
+		}
+	}
+
+	for remoteServer, questions := range remoteLookups {
+		rm := new(dns.Msg)
+		rm.RecursionDesired = true
+		rm.Question = questions
+		if s.config.Validate {
+			rm.SetEdns0(dnssecUDPSize, true)
+		}
+		resp, _, err := s.client.Exchange(rm, "["+remoteServer+"]:53")
+		if err != nil {
+			s.log.Debugln("meshname: lookup failed:", err)
+			atomic.AddUint64(&s.errors, 1)
+			continue
+		}
+		if s.config.Validate {
+			if err := s.validate(remoteServer, remoteDomains[remoteServer], resp); err != nil {
+				s.log.Debugln("meshname: rejecting answer from", remoteServer+":", err)
+				atomic.AddUint64(&s.errors, 1)
+				m.Rcode = dns.RcodeServerFailure
+				continue
+			}
+		}
+		// The additional section isn't validated, so it isn't passed on.
+		m.Answer = append(m.Answer, resp.Answer...)
+		m.Ns = append(m.Ns, resp.Ns...)
+	}
+
+	if err := w.WriteMsg(m); err != nil {
+		s.log.Debugln("meshname: error writing response:", err)
+		atomic.AddUint64(&s.errors, 1)
+	}
+}
+
+// cachedKeys are the DNSKEY records of a remote server, kept until their TTL
+// expires.
+type cachedKeys struct {
+	keys    []dns.RR
+	expires time.Time
+}
+
+// validate checks the signatures on resp against the DNSKEY of domain, which
+// must belong to the Yggdrasil address remoteServer. The keys are cached
+// until their TTL expires.
+func (s *MeshnameServer) validate(remoteServer string, domain string, resp *dns.Msg) error {
+	now := time.Now()
+	s.keyLock.Lock()
+	cached := s.keyCache[remoteServer]
+	s.keyLock.Unlock()
+	var keys []dns.RR
+	if cached != nil && now.Before(cached.expires) {
+		keys = cached.keys
+	} else {
+		var err error
+		if keys, err = s.fetchKeys(remoteServer, domain, now); err != nil {
+			return err
+		}
+	}
+	return verifyAnswer(net.ParseIP(remoteServer), keys, resp, now)
+}
+
+// fetchKeys queries remoteServer for the DNSKEY records of domain and caches
+// them, dropping the keys of other servers that have expired. Keys are only
+// cached when one of them owns the address of remoteServer, so that a failed
+// or forged answer is asked again next time.
+func (s *MeshnameServer) fetchKeys(remoteServer string, domain string, now time.Time) ([]dns.RR, error) {
+	km := new(dns.Msg)
+	km.SetQuestion(domain, dns.TypeDNSKEY)
+	km.SetEdns0(dnssecUDPSize, true)
+	kresp, _, err := s.client.Exchange(km, "["+remoteServer+"]:53")
+	if err != nil {
+		return nil, err
+	}
+	var keys []dns.RR
+	ttl := uint32(defaultTTL)
+	for _, rr := range kresp.Answer {
+		if rr.Header().Rrtype == dns.TypeDNSKEY {
+			keys = append(keys, rr)
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+	if len(ownerKeys(net.ParseIP(remoteServer), keys)) == 0 {
+		return nil, fmt.Errorf("no DNSKEY matching %s", remoteServer)
+	}
+	s.keyLock.Lock()
+	for server, cached := range s.keyCache {
+		if now.After(cached.expires) {
+			delete(s.keyCache, server)
+		}
+	}
+	s.keyCache[remoteServer] = &cachedKeys{keys: keys, expires: now.Add(time.Duration(ttl) * time.Second)}
+	s.keyLock.Unlock()
+	return keys, nil
+}
+
+// responseWriter captures the response of a DNS handler that is not served on
+// a DNS socket, e.g. for DNS-over-HTTPS or admin socket requests.
+type responseWriter struct {
+	local  net.Addr
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *responseWriter) LocalAddr() net.Addr  { return w.local }
+func (w *responseWriter) RemoteAddr() net.Addr { return w.remote }
+func (w *responseWriter) Close() error         { return nil }
+func (w *responseWriter) TsigStatus() error    { return nil }
+func (w *responseWriter) TsigTimersOnly(bool)  {}
+func (w *responseWriter) Hijack()              {}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = msg
+	return len(b), nil
+}
+
+func (w *responseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
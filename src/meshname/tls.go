@@ -0,0 +1,98 @@
+package meshname
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"sort"
+	"time"
+
+	_meshname "github.com/zhoreeq/meshname/pkg/meshname"
+)
+
+// The validity of self-signed certificates. NotAfter is the "no well-defined
+// expiration date" of RFC 5280.
+var (
+	notBefore = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	notAfter  = time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC)
+)
+
+// tlsConfig returns the TLS configuration shared by the DNS-over-TLS and
+// DNS-over-HTTPS listeners. Certificates are loaded from TLSCertFile and
+// TLSKeyFile when set, otherwise a self-signed certificate is generated for
+// the node's addresses and meshname domains.
+func (s *MeshnameServer) tlsConfig() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case s.config.TLSCertFile != "" || s.config.TLSKeyFile != "":
+		cert, err = tls.LoadX509KeyPair(s.config.TLSCertFile, s.config.TLSKeyFile)
+	default:
+		cert, err = s.selfSignedCertificate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// selfSignedCertificate returns a certificate for the node's ed25519 key,
+// so that clients can pin it across restarts. It is the same for as long as
+// the key and listeners are: ed25519 signatures are deterministic and the
+// serial number and validity don't depend on the time of the start. Its
+// subject alternative names are the node's Yggdrasil address, the addresses
+// that the TLS listeners are bound to and the node's meshname domains.
+// Clients which don't support ed25519 certificates, such as most browsers,
+// need TLSCertFile and TLSKeyFile.
+func (s *MeshnameServer) selfSignedCertificate() (tls.Certificate, error) {
+	if len(s.privateKey) != ed25519.PrivateKeySize {
+		return tls.Certificate{}, errors.New("no node key for a self-signed certificate")
+	}
+	public := s.privateKey.Public().(ed25519.PublicKey)
+	digest := sha256.Sum256(public)
+	serial := new(big.Int).SetBytes(digest[:16])
+	addr := s.core.Address()
+	label := _meshname.DomainFromIP(&addr)
+	ips := []net.IP{addr}
+	for _, listen := range []string{s.config.TLSListen, s.config.HTTPSListen} {
+		host, _, err := net.SplitHostPort(listen)
+		if ip := net.ParseIP(host); err == nil && ip != nil && !ip.IsUnspecified() {
+			ips = append(ips, ip)
+		}
+	}
+	var names []string
+	for tld := range s.networks {
+		names = append(names, label+"."+tld)
+	}
+	sort.Strings(names)
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: label + ".meshname",
+		},
+		DNSNames:              names,
+		IPAddresses:           ips,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	certbytes, err := x509.CreateCertificate(rand.Reader, &template, &template, public, s.privateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{certbytes},
+		PrivateKey:  s.privateKey,
+	}, nil
+}
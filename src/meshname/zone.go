@@ -0,0 +1,241 @@
+package meshname
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	_meshname "github.com/zhoreeq/meshname/pkg/meshname"
+)
+
+const defaultTTL = 3600
+
+// typeNXNAME is the pseudo-type of RFC 9824 that marks the owner of an NSEC
+// record as a name that doesn't exist.
+const typeNXNAME = 128
+
+// zone holds the records of this node's own meshname domain. The same records
+// are served under every top-level domain that meshname names resolve in.
+type zone struct {
+	origins []string
+	records map[string][]dns.RR // keyed by lower-case owner name
+	key     ed25519.PrivateKey
+	keys    map[string]*dns.DNSKEY // keyed by origin
+	soas    map[string]dns.RR      // keyed by origin
+}
+
+// newZone parses records given in zone file format relative to the meshname
+// domain of addr. An AAAA record pointing at addr and an SOA record are added
+// for the domain itself unless the records already define them, and if key is
+// given, a DNSKEY record is added so that the zone can be signed.
+func newZone(addr net.IP, tlds []string, records []string, key ed25519.PrivateKey) (*zone, error) {
+	label := _meshname.DomainFromIP(&addr)
+	origin := dns.Fqdn(label + ".meshname")
+	var parsed []dns.RR
+	hasApex, hasSOA := false, false
+	for _, record := range records {
+		zp := dns.NewZoneParser(strings.NewReader(record), origin, "")
+		zp.SetDefaultTTL(defaultTTL)
+		rr, ok := zp.Next()
+		if err := zp.Err(); err != nil {
+			return nil, fmt.Errorf("invalid record %q: %w", record, err)
+		}
+		if !ok {
+			continue
+		}
+		if !dns.IsSubDomain(origin, rr.Header().Name) {
+			return nil, fmt.Errorf("record %q is outside of zone %s", record, origin)
+		}
+		if dns.CanonicalName(rr.Header().Name) == origin {
+			hasApex = hasApex || rr.Header().Rrtype == dns.TypeAAAA
+			hasSOA = hasSOA || rr.Header().Rrtype == dns.TypeSOA
+		}
+		parsed = append(parsed, rr)
+	}
+	if !hasApex {
+		parsed = append(parsed, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: origin, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: defaultTTL},
+			AAAA: addr,
+		})
+	}
+	if !hasSOA {
+		parsed = append(parsed, &dns.SOA{
+			Hdr:     dns.RR_Header{Name: origin, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: defaultTTL},
+			Ns:      origin,
+			Mbox:    "hostmaster." + origin,
+			Serial:  1,
+			Refresh: defaultTTL,
+			Retry:   defaultTTL,
+			Expire:  defaultTTL,
+			Minttl:  defaultTTL,
+		})
+	}
+
+	z := &zone{
+		records: make(map[string][]dns.RR),
+		key:     key,
+		keys:    make(map[string]*dns.DNSKEY),
+		soas:    make(map[string]dns.RR),
+	}
+	for _, tld := range tlds {
+		tldOrigin := dns.Fqdn(label + "." + tld)
+		z.origins = append(z.origins, tldOrigin)
+		if key != nil {
+			z.keys[tldOrigin] = dnskeyFor(tldOrigin, key.Public().(ed25519.PublicKey))
+			z.records[tldOrigin] = append(z.records[tldOrigin], z.keys[tldOrigin])
+		}
+		for _, rr := range parsed {
+			rr = dns.Copy(rr)
+			name := dns.CanonicalName(rr.Header().Name)
+			rr.Header().Name = strings.TrimSuffix(name, origin) + tldOrigin
+			z.records[rr.Header().Name] = append(z.records[rr.Header().Name], rr)
+			if rr.Header().Rrtype == dns.TypeSOA && rr.Header().Name == tldOrigin {
+				z.soas[tldOrigin] = rr
+			}
+		}
+	}
+	return z, nil
+}
+
+// CheckRecord returns an error if record is not valid zone file syntax for
+// the meshname domain of addr, as given in MeshnameConfig.Records.
+func CheckRecord(addr net.IP, record string) error {
+	_, err := newZone(addr, []string{"meshname"}, []string{record}, nil)
+	return err
+}
+
+func (z *zone) contains(name string) bool {
+	return z.originFor(name) != ""
+}
+
+// originFor returns the origin that name belongs to, or an empty string if
+// name is not in the zone.
+func (z *zone) originFor(name string) string {
+	for _, origin := range z.origins {
+		if dns.IsSubDomain(origin, name) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// lookup returns the records for name matching qtype, and whether name exists
+// in the zone at all.
+func (z *zone) lookup(name string, qtype uint16) ([]dns.RR, bool) {
+	records, ok := z.records[dns.CanonicalName(name)]
+	if !ok {
+		return nil, false
+	}
+	var result []dns.RR
+	for _, rr := range records {
+		if qtype == dns.TypeANY || rr.Header().Rrtype == qtype {
+			result = append(result, rr)
+		}
+	}
+	return result, true
+}
+
+// denial returns an NSEC record owned by name whose type bitmap lists the
+// types that name holds, following the compact denial of existence of RFC
+// 9824. As it covers no other name, its signature can't be replayed to deny
+// any other name or type. The bitmap of a name that doesn't exist holds
+// NXNAME.
+func (z *zone) denial(name string) dns.RR {
+	name = dns.CanonicalName(name)
+	types := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+	if records, ok := z.records[name]; ok {
+		for _, rr := range records {
+			types = append(types, rr.Header().Rrtype)
+		}
+	} else {
+		types = append(types, typeNXNAME)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	bitmap := types[:1]
+	for _, t := range types[1:] {
+		if t != bitmap[len(bitmap)-1] {
+			bitmap = append(bitmap, t)
+		}
+	}
+	ttl := uint32(defaultTTL)
+	if soa, ok := z.soas[z.originFor(name)].(*dns.SOA); ok && soa.Minttl < ttl {
+		ttl = soa.Minttl
+	}
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+		NextDomain: `\000.` + name,
+		TypeBitMap: bitmap,
+	}
+}
+
+func containsRR(records []dns.RR, rr dns.RR) bool {
+	for _, r := range records {
+		if r == rr {
+			return true
+		}
+	}
+	return false
+}
+
+// handleZoneRequest answers queries for this node's own meshname domain
+// authoritatively and refuses everything else.
+func (s *MeshnameServer) handleZoneRequest(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	var denied []string
+	for _, q := range r.Question {
+		if !s.zone.contains(q.Name) {
+			s.log.Debugln("meshname: refusing query for", q.Name, "from", w.RemoteAddr())
+			m.Rcode = dns.RcodeRefused
+			m.Authoritative = false
+			m.Answer, m.Ns = nil, nil
+			break
+		}
+		records, ok := s.zone.lookup(q.Name, q.Qtype)
+		if !ok {
+			m.Rcode = dns.RcodeNameError
+		}
+		if len(records) == 0 {
+			denied = append(denied, q.Name)
+			if soa := s.zone.soas[s.zone.originFor(q.Name)]; !containsRR(m.Ns, soa) {
+				m.Ns = append(m.Ns, soa)
+			}
+			continue
+		}
+		m.Answer = append(m.Answer, records...)
+	}
+
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(dnssecUDPSize, opt.Do())
+		if opt.Do() && s.zone.key != nil {
+			// Denials are proven by signed NSEC records, which for names
+			// that don't exist come with NOERROR as in RFC 9824.
+			for _, name := range denied {
+				m.Ns = append(m.Ns, s.zone.denial(name))
+			}
+			if m.Rcode == dns.RcodeNameError {
+				m.Rcode = dns.RcodeSuccess
+			}
+			for _, section := range []*[]dns.RR{&m.Answer, &m.Ns} {
+				sigs, err := s.zone.sign(*section, time.Now())
+				if err != nil {
+					s.log.Errorln("meshname: failed to sign records:", err)
+				}
+				*section = append(*section, sigs...)
+			}
+		}
+	}
+
+	if err := w.WriteMsg(m); err != nil {
+		s.log.Debugln("meshname: error writing response:", err)
+		atomic.AddUint64(&s.errors, 1)
+	}
+}
@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+	"github.com/yggdrasil-network/yggdrasil-go/src/version"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+	"github.com/popura-network/Popura/src/tun"
+)
+
+const (
+	metricsPath     = "/metrics"
+	shutdownTimeout = 5 * time.Second
+)
+
+// Options are passed to MetricsServer.Init to export the metrics of the other
+// parts of the node. Any of them may be nil. Started is the time the node was
+// started, which defaults to the time the server is started.
+type Options struct {
+	TUN     *tun.TunAdapter
+	Peers   popura.PeerLister // the core if nil
+	Sources []Source
+	Started time.Time
+}
+
+// MetricsServer serves metrics of the node over HTTP for Prometheus to
+// scrape.
+type MetricsServer struct {
+	core    *core.Core
+	log     *logging.Logger
+	config  popura.MetricsConfig
+	options Options
+	server  *http.Server
+	started time.Time
+	lock    sync.RWMutex
+}
+
+func (m *MetricsServer) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
+	m.core = yggcore
+	m.log = log
+	m.config = popConfig.Metrics
+	if opts, ok := options.(Options); ok {
+		m.options = opts
+	}
+	m.started = m.options.Started
+	if m.options.Peers == nil {
+		m.options.Peers = yggcore
+	}
+	if m.config.Enable {
+		if _, _, err := net.SplitHostPort(m.config.Listen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MetricsServer) Start() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.config.Enable {
+		return nil
+	}
+	if m.server != nil {
+		return errors.New("already started")
+	}
+	listener, err := net.Listen("tcp", m.config.Listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, m.handleMetrics)
+	m.server = &http.Server{Handler: mux}
+	if m.started.IsZero() {
+		m.started = time.Now()
+	}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			m.log.Errorln("metrics: server failed:", err)
+		}
+	}(m.server)
+	m.log.Infoln("metrics: listening on", "http://"+listener.Addr().String()+metricsPath)
+	return nil
+}
+
+func (m *MetricsServer) Stop() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := m.server.Shutdown(ctx)
+	m.server = nil
+	return err
+}
+
+func (m *MetricsServer) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
+func (m *MetricsServer) SetupAdminHandlers(a *admin.AdminSocket)                                   {}
+
+func (m *MetricsServer) IsStarted() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.server != nil
+}
+
+func (m *MetricsServer) Health() error {
+	if m.config.Enable && !m.IsStarted() {
+		return errors.New("server is not running")
+	}
+	return nil
+}
+
+func (m *MetricsServer) handleMetrics(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w := newWriter(rw)
+	m.writeMetrics(w)
+	for _, source := range m.options.Sources {
+		if source != nil {
+			source.WriteMetrics(w)
+		}
+	}
+	if err := w.flush(); err != nil {
+		m.log.Debugln("metrics: error writing response:", err)
+	}
+}
+
+// writeMetrics writes the metrics of the Yggdrasil core and TUN adapter.
+func (m *MetricsServer) writeMetrics(w *Writer) {
+	w.Gauge("popura_build_info", "Build name and version of the node.", 1,
+		"name", version.BuildName(), "version", version.BuildVersion())
+	m.lock.RLock()
+	w.Gauge("popura_uptime_seconds", "Time since the node was started.", time.Since(m.started).Seconds())
+	m.lock.RUnlock()
+
+	peers := m.options.Peers.GetPeers()
+	w.Gauge("popura_peers", "Number of connected peers.", float64(len(peers)))
+	for _, p := range peers {
+		w.Counter("popura_peer_rx_bytes_total", "Bytes received from a peer.", float64(p.RXBytes),
+			"key", hex.EncodeToString(p.Key), "remote", p.Remote)
+	}
+	for _, p := range peers {
+		w.Counter("popura_peer_tx_bytes_total", "Bytes sent to a peer.", float64(p.TXBytes),
+			"key", hex.EncodeToString(p.Key), "remote", p.Remote)
+	}
+	for _, p := range peers {
+		w.Gauge("popura_peer_uptime_seconds", "Time since the connection to a peer was established.", p.Uptime.Seconds(),
+			"key", hex.EncodeToString(p.Key), "remote", p.Remote)
+	}
+	w.Gauge("popura_sessions", "Number of open sessions with other nodes.", float64(len(m.core.GetSessions())))
+	w.Gauge("popura_dht_entries", "Number of entries in the DHT.", float64(len(m.core.GetDHT())))
+
+	if m.options.TUN != nil && m.options.TUN.IsStarted() {
+		w.Gauge("popura_tun_mtu", "MTU of the TUN adapter.", float64(m.options.TUN.MTU()),
+			"interface", m.options.TUN.Name())
+	}
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Source is implemented by Popura modules that export their own metrics.
+type Source interface {
+	WriteMetrics(w *Writer)
+}
+
+// Writer writes metrics in the Prometheus text exposition format. The samples
+// of a metric must be written one after another, as HELP and TYPE lines are
+// only written before the first of them.
+type Writer struct {
+	w       *bufio.Writer
+	written map[string]bool
+}
+
+func newWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:       bufio.NewWriter(w),
+		written: make(map[string]bool),
+	}
+}
+
+// Gauge writes a sample of a metric that can go up and down. Labels are given
+// as name and value pairs.
+func (w *Writer) Gauge(name, help string, value float64, labels ...string) {
+	w.sample(name, "gauge", help, value, labels)
+}
+
+// Counter writes a sample of a metric that only goes up. By convention, the
+// names of counters end in _total.
+func (w *Writer) Counter(name, help string, value float64, labels ...string) {
+	w.sample(name, "counter", help, value, labels)
+}
+
+// Bool writes a gauge that is 1 if value is true and 0 otherwise.
+func (w *Writer) Bool(name, help string, value bool, labels ...string) {
+	v := 0.0
+	if value {
+		v = 1
+	}
+	w.Gauge(name, help, v, labels...)
+}
+
+func (w *Writer) sample(name, typ, help string, value float64, labels []string) {
+	if !w.written[name] {
+		w.written[name] = true
+		w.w.WriteString("# HELP " + name + " " + helpEscaper.Replace(help) + "\n")
+		w.w.WriteString("# TYPE " + name + " " + typ + "\n")
+	}
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			w.w.WriteString(labels[i] + `="` + labelEscaper.Replace(labels[i+1]) + `"`)
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteByte(' ')
+	w.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.w.WriteByte('\n')
+}
+
+func (w *Writer) flush() error {
+	return w.w.Flush()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newWriter(&out)
+	w.Gauge("popura_peers", "Number of peers", 3)
+	w.Counter("popura_bytes_total", "Bytes sent to a peer", 1024, "peer", "tls://[::1]:443", "direction", "tx")
+	w.Counter("popura_bytes_total", "Bytes sent to a peer", 0.5, "peer", `a "quoted\" name`+"\n", "direction", "rx")
+	w.Bool("popura_up", "Whether the node is up\nand \\running", true)
+	w.Bool("popura_tun", "Whether the TUN adapter is up", false)
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP popura_peers Number of peers
+# TYPE popura_peers gauge
+popura_peers 3
+# HELP popura_bytes_total Bytes sent to a peer
+# TYPE popura_bytes_total counter
+popura_bytes_total{peer="tls://[::1]:443",direction="tx"} 1024
+popura_bytes_total{peer="a \"quoted\\\" name\n",direction="rx"} 0.5
+# HELP popura_up Whether the node is up\nand \\running
+# TYPE popura_up gauge
+popura_up 1
+# HELP popura_tun Whether the TUN adapter is up
+# TYPE popura_tun gauge
+popura_tun 0
+`
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -1,8 +1,21 @@
 package popura
 
 type PopuraConfig struct {
+	// PrivateKeyPath is set from the top-level PrivateKeyPath option, rather
+	// than from the Popura section. See ResolvePrivateKey.
+	PrivateKeyPath string `json:"-" mapstructure:"-"`
+
 	Autopeering AutopeeringConfig `comment:"Autopeering description"`
 	Meshname    MeshnameConfig    `comment:"DNS server description"`
+	Metrics     MetricsConfig     `comment:"Prometheus metrics"`
+	API         APIConfig         `comment:"HTTP gateway to the admin API"`
+	Health      HealthConfig      `comment:"Health checks"`
+	Hooks       HooksConfig       `comment:"Event hooks"`
+	Logging     LoggingConfig     `comment:"Logging"`
+	AdminAuth   AdminAuthConfig   `comment:"Authentication on the admin socket"`
+	Accounting  AccountingConfig  `comment:"Traffic accounting"`
+	Shaping     ShapingConfig     `comment:"Bandwidth limits of peers"`
+	Firewall    FirewallConfig    `comment:"Packet filter between the network and the TUN interface"`
 }
 
 type AutopeeringConfig struct {
@@ -10,8 +23,86 @@ type AutopeeringConfig struct {
 }
 
 type MeshnameConfig struct {
-	Enable bool   `comment:"Enable or disable the DNS server"`
-	Listen string `comment:"Listen address for the DNS server"`
+	Enable          bool     `comment:"Enable or disable the DNS server"`
+	Listen          string   `comment:"Listen address for the DNS server"`
+	TLSListen       string   `comment:"Listen address for DNS-over-TLS, leave empty to disable"`
+	HTTPSListen     string   `comment:"Listen address for DNS-over-HTTPS, leave empty to disable"`
+	TLSCertFile     string   `comment:"PEM certificate for DNS-over-TLS and DNS-over-HTTPS, leave empty\nto use a certificate self-signed with the node's ed25519 key"`
+	TLSKeyFile      string   `comment:"PEM private key for TLSCertFile"`
+	ListenYggdrasil bool     `comment:"Also serve this node's own meshname zone on port 53 of its Yggdrasil\naddress, so that other nodes can look up the records below"`
+	Records         []string `comment:"Records of this node's own meshname zone in zone file format,\nrelative to the node's meshname domain, e.g. \"www AAAA 200::1\".\nAn AAAA record for the domain itself is added automatically."`
+	Validate        bool     `comment:"Only accept answers from other nodes' meshname servers when they\nare signed by the key that owns the address in the domain name. Answers\nthat a name doesn't exist must carry a signed NSEC record for the name."`
+	RateLimit       float64  `comment:"Maximum number of queries per second accepted from a single client,\nor 0 to disable rate limiting"`
+	RateBurst       uint     `comment:"Number of queries a single client may send at once before\nRateLimit applies"`
+}
+
+type MetricsConfig struct {
+	Enable bool   `comment:"Serve metrics of this node in Prometheus format over HTTP"`
+	Listen string `comment:"Listen address for the metrics server, which serves them at /metrics"`
+}
+
+type APIConfig struct {
+	Enable    bool   `comment:"Serve the commands of the admin socket over HTTP at /api/, with an\nOpenAPI document at /api/openapi.json. Requires AdminListen."`
+	Listen    string `comment:"Listen address for the API server"`
+	Dashboard bool   `comment:"Also serve a web dashboard of this node at /, which uses the API"`
+}
+
+type HealthConfig struct {
+	Enable   bool   `comment:"Serve liveness and readiness probes of this node over HTTP at\n/healthz and /readyz"`
+	Listen   string `comment:"Listen address for the health check server"`
+	MinPeers int    `comment:"Number of peers this node needs to be reported as ready"`
+}
+
+type HooksConfig struct {
+	Commands   map[string][]string `comment:"Commands to run on events, by type of event: peer_connected,\npeer_disconnected, session_opened, session_closed, autopeer_added,\nautopeer_removed, meshname_listener_failed, or \"*\" for all of them. Commands are run\nwithout a shell, and get the details of the event in POPURA_EVENT_*\nenvironment variables."`
+	MaxRunning int                 `comment:"Maximum number of events whose hook commands run at once"`
+	Timeout    int                 `comment:"Seconds after which a hook command is killed"`
+}
+
+type LoggingConfig struct {
+	Levels map[string]string `comment:"Log level of single modules, e.g. { core: \"warn\", autopeering: \"debug\" }.\nModules are main, core, admin, multicast, tun, meshname, autopeering,\nmetrics, api, health, events, hooks, accounting, shaping and firewall.\nLevels given with -loglevel take precedence."`
+}
+
+type AccountingConfig struct {
+	Enable      bool   `comment:"Count the traffic of each session and peer link by day, and keep the\ncounts across restarts"`
+	StateDir    string `comment:"Directory in which the counts are stored"`
+	Days        int    `comment:"Number of days for which daily counts are kept"`
+	MetricsKeys int    `comment:"Number of the keys, and of the peer links, with the most traffic whose\ncounts are also exported as metrics of their own, or 0 to only export the\nsums of all of them"`
+}
+
+type ShapingConfig struct {
+	Enable   bool                     `comment:"Limit the bandwidth of peers: inbound peers that connect to the relay\nlisteners in Listen, and the tcp://, tls:// and socks:// peers in the\ntop-level Peers and those added by autopeering"`
+	Listen   []string                 `comment:"Addresses to accept limited peers on, e.g. \"tls://0.0.0.0:443\". These must\nnot also be in the top-level Listen. May be empty to only limit the peers\nthis node connects to."`
+	Upload   uint64                   `comment:"Bytes per second that may be sent over each link to a peer, or 0 for\nno limit"`
+	Download uint64                   `comment:"Bytes per second that may be received over each link from a peer,\nor 0 for no limit"`
+	Keys     map[string]ShapingLimits `comment:"Limits shared by all links to the peer with a public key, by key in\nhex, e.g. { \"<key>\": { Upload: 100000, Download: 100000 } }. They apply\nwithin a second of the peer connecting, or at once to peers in Peers\npinned to that key."`
+}
+
+type ShapingLimits struct {
+	Upload   uint64
+	Download uint64
+}
+
+type FirewallConfig struct {
+	Enable  bool           `comment:"Filter the packets that arrive from the network before they reach the\nTUN interface. Packets of connections opened from this node are always\naccepted."`
+	Default string         `comment:"What to do with packets that match no rule, \"accept\" or \"drop\""`
+	Rules   []FirewallRule `comment:"Rules tried in order, the first that matches a packet deciding what\nhappens to it, e.g. [ { Action: \"accept\", Protocol: \"tcp\", Port: \"22\" } ].\nAction is \"accept\" or \"drop\". Key (a public key in hex), Source (a\nsubnet such as \"200::/7\"), Protocol (\"tcp\", \"udp\" or \"icmpv6\") and Port\n(a destination port or a range such as \"8000-8100\") match any packet when\nleft out."`
+}
+type FirewallRule struct {
+	Action   string
+	Key      string
+	Source   string
+	Protocol string
+	Port     string
+}
+
+type AdminAuthConfig struct {
+	Tokens []AdminToken `comment:"Tokens that clients of the admin socket must give, e.g.\n[ { Token: \"secret\", Role: \"admin\" } ]. The role \"admin\" may run every\ncommand, the role \"read\" only those that don't change the node. Leave\nempty to let anyone who can connect to AdminListen run every command."`
+}
+
+type AdminToken struct {
+	Token string
+	Role  string
 }
 
 func GenerateConfig() *PopuraConfig {
@@ -21,6 +112,47 @@ func GenerateConfig() *PopuraConfig {
 
 	popConfig.Meshname.Enable = false
 	popConfig.Meshname.Listen = "[::1]:53535"
+	popConfig.Meshname.TLSListen = ""
+	popConfig.Meshname.HTTPSListen = ""
+	popConfig.Meshname.ListenYggdrasil = false
+	popConfig.Meshname.Records = []string{}
+	popConfig.Meshname.Validate = false
+	popConfig.Meshname.RateLimit = 20
+	popConfig.Meshname.RateBurst = 40
+
+	popConfig.Metrics.Enable = false
+	popConfig.Metrics.Listen = "[::1]:9464"
+
+	popConfig.API.Enable = false
+	popConfig.API.Listen = "[::1]:9466"
+	popConfig.API.Dashboard = false
+
+	popConfig.Health.Enable = false
+	popConfig.Health.Listen = "[::1]:9465"
+	popConfig.Health.MinPeers = 1
+
+	popConfig.Hooks.Commands = map[string][]string{}
+	popConfig.Hooks.MaxRunning = 4
+	popConfig.Hooks.Timeout = 30
+
+	popConfig.Logging.Levels = map[string]string{}
+
+	popConfig.AdminAuth.Tokens = []AdminToken{}
+
+	popConfig.Accounting.Enable = false
+	popConfig.Accounting.StateDir = "/var/lib/yggdrasil"
+	popConfig.Accounting.Days = 90
+	popConfig.Accounting.MetricsKeys = 0
+
+	popConfig.Shaping.Enable = false
+	popConfig.Shaping.Listen = []string{}
+	popConfig.Shaping.Upload = 0
+	popConfig.Shaping.Download = 0
+	popConfig.Shaping.Keys = map[string]ShapingLimits{}
+
+	popConfig.Firewall.Enable = false
+	popConfig.Firewall.Default = "drop"
+	popConfig.Firewall.Rules = []FirewallRule{}
 
 	return &popConfig
 }
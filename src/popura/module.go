@@ -1,20 +1,35 @@
 package popura
 
 import (
-	"github.com/gologme/log"
-
 	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
 	"github.com/yggdrasil-network/yggdrasil-go/src/config"
 	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/logging"
 )
 
 // Module is an interface that defines which functions must be supported by a
 // given Popura module.
 type Module interface {
-	Init(yggcore *core.Core, yggConfig *config.NodeConfig, popuraConf *PopuraConfig, log *log.Logger, options interface{}) error
+	Init(yggcore *core.Core, yggConfig *config.NodeConfig, popuraConf *PopuraConfig, log *logging.Logger, options interface{}) error
 	Start() error
 	Stop() error
 	UpdateConfig(yggConf *config.NodeConfig, popuraConf *PopuraConfig)
 	SetupAdminHandlers(a *admin.AdminSocket)
 	IsStarted() bool
+	// Health returns an error if the module is enabled but not working.
+	Health() error
+}
+
+// PeerLister lists the peers of the core, as *core.Core does. The shaper lists
+// the peers it relays under their own remote addresses, which the core never
+// learns, so modules that report on peers take one instead of using the core.
+type PeerLister interface {
+	GetPeers() []core.PeerInfo
+}
+
+// PeerRelay returns the URI that the core should connect to to reach a peer,
+// as the shaper relays the peers the node connects to.
+type PeerRelay interface {
+	RelayPeer(uri string) (string, error)
 }
@@ -0,0 +1,102 @@
+package popura
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+)
+
+// PrivateKeyEnv is the environment variable that the node's private key can be
+// given in, as an alternative to the PrivateKey and PrivateKeyPath options.
+const PrivateKeyEnv = "POPURA_PRIVATE_KEY"
+
+// ResolvePrivateKey replaces cfg.PrivateKey with the key given in the
+// PrivateKeyEnv environment variable or, if that is not set, with the key
+// stored in the file at path. If neither is set, cfg is left as it is. The
+// name of the option that the key was taken from is returned, also when that
+// option holds an invalid key. Warnings about the key file are logged to log.
+func ResolvePrivateKey(cfg *config.NodeConfig, path string, log core.Logger) (string, error) {
+	var source string
+	var sk ed25519.PrivateKey
+	var err error
+	switch {
+	case os.Getenv(PrivateKeyEnv) != "":
+		source = PrivateKeyEnv
+		sk, err = ParsePrivateKey([]byte(os.Getenv(PrivateKeyEnv)))
+	case path != "":
+		source = "PrivateKeyPath"
+		sk, err = LoadPrivateKey(path, log)
+	default:
+		return "PrivateKey", nil
+	}
+	if err != nil {
+		return source, err
+	}
+	cfg.PrivateKey = hex.EncodeToString(sk)
+	return source, nil
+}
+
+// LoadPrivateKey reads a private key from the file at path, in any format
+// accepted by ParsePrivateKey. Keys that other users can modify are refused.
+// The file may be readable by its group, so that the key can be shared with a
+// service account, but a warning is logged if every user can read it.
+func LoadPrivateKey(path string, log core.Logger) (ed25519.PrivateKey, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	// File modes don't reflect access rights on Windows.
+	if perm := info.Mode().Perm(); runtime.GOOS != "windows" {
+		if perm&0022 != 0 {
+			return nil, fmt.Errorf("permissions %04o for %s are too open, it must not be writable by other users", perm, path)
+		}
+		if perm&0004 != 0 {
+			log.Warnf("Permissions %04o for %s are too open, the private key is readable by every user", perm, path)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(data)
+}
+
+// ParsePrivateKey parses an ed25519 private key, either PEM encoded in PKCS #8
+// form or hex encoded like the PrivateKey option.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	data = bytes.TrimSpace(data)
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "PRIVATE KEY" {
+			return nil, fmt.Errorf("unexpected PEM block type %q", block.Type)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		sk, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("not an ed25519 private key")
+		}
+		return sk, nil
+	}
+	sk, err := hex.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(sk) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("expected %d bytes, got %d", ed25519.PrivateKeySize, len(sk))
+	}
+	return ed25519.PrivateKey(sk), nil
+}
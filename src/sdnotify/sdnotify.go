@@ -0,0 +1,97 @@
+// Package sdnotify sends notifications to the service manager, usually
+// systemd, over the datagram socket given by the NOTIFY_SOCKET environment
+// variable, as described in sd_notify(3).
+package sdnotify
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SocketEnv      = "NOTIFY_SOCKET"
+	WatchdogEnv    = "WATCHDOG_USEC"
+	WatchdogPIDEnv = "WATCHDOG_PID"
+)
+
+// Notifier sends notifications to the service manager.
+type Notifier struct {
+	conn *net.UnixConn
+}
+
+// New returns a Notifier for the socket given by NOTIFY_SOCKET, or nil if the
+// node wasn't started by a service manager that expects notifications.
+func New() (*Notifier, error) {
+	socket := os.Getenv(SocketEnv)
+	if socket == "" {
+		return nil, nil
+	}
+	return Dial(socket)
+}
+
+// Dial returns a Notifier for the socket at path. Paths starting with "@"
+// refer to the abstract namespace on Linux.
+func Dial(path string) (*Notifier, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{conn: conn}, nil
+}
+
+// Notify sends the given state, one or more lines of the form VARIABLE=value.
+func (n *Notifier) Notify(state string) error {
+	_, err := n.conn.Write([]byte(state))
+	return err
+}
+
+// Ready tells the service manager that the node has started, along with a
+// status line.
+func (n *Notifier) Ready(status string) error {
+	return n.Notify("READY=1\nSTATUS=" + status)
+}
+
+// Status sends a line describing the state of the node.
+func (n *Notifier) Status(status string) error {
+	return n.Notify("STATUS=" + status)
+}
+
+// Watchdog tells the service manager that the node is still healthy.
+func (n *Notifier) Watchdog() error {
+	return n.Notify("WATCHDOG=1")
+}
+
+// Stopping tells the service manager that the node is shutting down.
+func (n *Notifier) Stopping() error {
+	return n.Notify("STOPPING=1")
+}
+
+func (n *Notifier) Close() error {
+	return n.conn.Close()
+}
+
+// WatchdogInterval returns the interval at which the service manager expects
+// watchdog pings, as given by WATCHDOG_USEC, or zero if it doesn't expect any.
+// Pings should be sent at least twice as often.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv(WatchdogEnv)
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv(WatchdogPIDEnv); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// The pings are expected from another process.
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(usec), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, errors.New(WatchdogEnv + " must be positive")
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
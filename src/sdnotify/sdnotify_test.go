@@ -0,0 +1,83 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Unix datagram sockets are not supported: %s", err)
+	}
+	defer listener.Close()
+
+	t.Setenv(SocketEnv, path)
+	n, err := New()
+	if err != nil {
+		t.Fatalf("Failed to connect to the socket: %s", err)
+	}
+	if n == nil {
+		t.Fatal("No notifier returned with " + SocketEnv + " set")
+	}
+	defer n.Close()
+
+	for _, test := range []struct {
+		send func() error
+		want string
+	}{
+		{func() error { return n.Ready("2 peers") }, "READY=1\nSTATUS=2 peers"},
+		{func() error { return n.Status("3 peers") }, "STATUS=3 peers"},
+		{n.Watchdog, "WATCHDOG=1"},
+		{n.Stopping, "STOPPING=1"},
+	} {
+		if err := test.send(); err != nil {
+			t.Fatalf("Failed to send %q: %s", test.want, err)
+		}
+		buf := make([]byte, 1024)
+		_ = listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		size, err := listener.Read(buf)
+		if err != nil {
+			t.Fatalf("Failed to receive %q: %s", test.want, err)
+		}
+		if got := string(buf[:size]); got != test.want {
+			t.Errorf("Received %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestNotifyUnset(t *testing.T) {
+	t.Setenv(SocketEnv, "")
+	n, err := New()
+	if n != nil || err != nil {
+		t.Errorf("New() = %v, %v without %s, want nil, nil", n, err, SocketEnv)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for _, test := range []struct {
+		usec, pid string
+		want      time.Duration
+		fails     bool
+	}{
+		{"", "", 0, false},
+		{"30000000", "", 30 * time.Second, false},
+		{"30000000", pid, 30 * time.Second, false},
+		{"30000000", "1", 0, false},
+		{"soon", "", 0, true},
+		{"0", "", 0, true},
+	} {
+		t.Setenv(WatchdogEnv, test.usec)
+		t.Setenv(WatchdogPIDEnv, test.pid)
+		got, err := WatchdogInterval()
+		if (err != nil) != test.fails || got != test.want {
+			t.Errorf("WatchdogInterval() with %q, %q = %v, %v", test.usec, test.pid, got, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package shaping
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sort"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+type GetShapingRequest struct{}
+
+// GetShapingResponse is the response of the core's getPeers, with the real
+// remote addresses and the limits of the peers connected through the shaper.
+type GetShapingResponse struct {
+	Peers []PeerEntry `json:"peers"`
+}
+
+type PeerEntry struct {
+	admin.PeerEntry
+	UploadLimit   admin.DataUnit `json:"upload_limit,omitempty"`
+	DownloadLimit admin.DataUnit `json:"download_limit,omitempty"`
+	Throttled     bool           `json:"throttled,omitempty"`
+	Delayed       float64        `json:"throttled_seconds,omitempty"`
+}
+
+// getShapingHandler lists the peers as the core's getPeers does, but with the
+// remote addresses of the peers of links rather than those of the links'
+// connections to the core, and their limits.
+func (s *Shaper) getShapingHandler(req *GetShapingRequest, res *GetShapingResponse) error {
+	peers := s.core.GetPeers()
+	links := s.rename(peers)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res.Peers = make([]PeerEntry, 0, len(peers))
+	for i, p := range peers {
+		addr := address.AddrForKey(p.Key)
+		entry := PeerEntry{PeerEntry: admin.PeerEntry{
+			IPAddress: net.IP(addr[:]).String(),
+			PublicKey: hex.EncodeToString(p.Key),
+			Port:      p.Port,
+			Priority:  p.Priority,
+			Coords:    p.Coords,
+			Remote:    p.Remote,
+			RXBytes:   admin.DataUnit(p.RXBytes),
+			TXBytes:   admin.DataUnit(p.TXBytes),
+			Uptime:    p.Uptime.Seconds(),
+		}}
+		if l := links[i]; l != nil {
+			for _, lims := range []*limits{&l.limits, l.keyLims} {
+				if lims == nil {
+					continue
+				}
+				entry.UploadLimit = minLimit(entry.UploadLimit, lims.up.limit())
+				entry.DownloadLimit = minLimit(entry.DownloadLimit, lims.down.limit())
+				for _, b := range []*bucket{lims.up, lims.down} {
+					throttled, delayed := b.state()
+					entry.Throttled = entry.Throttled || throttled
+					entry.Delayed += delayed.Seconds()
+				}
+			}
+		}
+		res.Peers = append(res.Peers, entry)
+	}
+	sort.Slice(res.Peers, func(i, j int) bool {
+		if res.Peers[i].Port == res.Peers[j].Port {
+			return res.Peers[i].Priority < res.Peers[j].Priority
+		}
+		return res.Peers[i].Port < res.Peers[j].Port
+	})
+	return nil
+}
+
+// minLimit returns the lower of two limits, where 0 is no limit.
+func minLimit(a admin.DataUnit, b uint64) admin.DataUnit {
+	if b != 0 && (a == 0 || admin.DataUnit(b) < a) {
+		return admin.DataUnit(b)
+	}
+	return a
+}
+
+func (s *Shaper) SetupAdminHandlers(a *admin.AdminSocket) {
+	if !s.config.Enable {
+		return
+	}
+	_ = a.AddHandler(
+		"getShaping", "Show directly connected peers with their limits", []string{},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &GetShapingRequest{}
+			res := &GetShapingResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := s.getShapingHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+}
@@ -0,0 +1,79 @@
+package shaping
+
+import (
+	"sync"
+	"time"
+)
+
+// minBurst is the least a bucket holds, so that a full read of a connection
+// never has to wait for more than it allows.
+const minBurst = relayBuffer
+
+// bucket is a token bucket limiting a rate of bytes per second. Bytes may be
+// taken before they are available, and the taker then waits until they would
+// have been, so a bucket never blocks anything but its taker.
+type bucket struct {
+	rate    float64 // bytes per second
+	burst   float64
+	tokens  float64
+	last    time.Time
+	until   time.Time     // end of the last wait
+	delayed time.Duration // total time takers have waited
+	lock    sync.Mutex
+}
+
+// newBucket returns a bucket for rate, or nil if rate is 0, as a nil bucket
+// doesn't limit anything.
+func newBucket(rate uint64) *bucket {
+	if rate == 0 {
+		return nil
+	}
+	b := &bucket{rate: float64(rate), burst: float64(rate)}
+	if b.burst < minBurst {
+		b.burst = minBurst
+	}
+	b.tokens = b.burst
+	b.last = time.Now()
+	return b
+}
+
+// take takes n bytes and returns how long to wait before using them.
+func (b *bucket) take(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.until = now.Add(wait)
+	b.delayed += wait
+	return wait
+}
+
+// state returns whether takers are waiting and for how long they have waited
+// in total.
+func (b *bucket) state() (throttled bool, delayed time.Duration) {
+	if b == nil {
+		return false, 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return time.Now().Before(b.until), b.delayed
+}
+
+func (b *bucket) limit() uint64 {
+	if b == nil {
+		return 0
+	}
+	return uint64(b.rate)
+}
@@ -0,0 +1,383 @@
+package shaping
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+const (
+	relayBuffer      = 16 << 10
+	identifyInterval = time.Second
+)
+
+// limits are the buckets of a direction of traffic: up is sent to a peer,
+// down is received from it.
+type limits struct {
+	up, down *bucket
+}
+
+// link is a connection between a peer and the core, relayed by the shaper.
+type link struct {
+	remote  string // URI of the peer, as the core would have named it
+	local   string // address of the core's peer on the connection to it
+	limits  limits
+	key     string  // public key in hex once known, guarded by Shaper.lock
+	keyLims *limits // limits of the key, guarded by Shaper.lock
+	peer    net.Conn
+	backend net.Conn
+}
+
+// Shaper limits the traffic of peers. The core can't be given connections,
+// nor shape its own, so the shaper accepts them on the addresses in
+// Popura.Shaping.Listen and relays each of them to a listener of the core on
+// the loopback interface, waiting as long as the limits of the link and of
+// the public key of its peer require. Peers the node connects to are relayed
+// the other way, by a listener on the loopback interface that the core
+// connects to instead of the peer, as RelayPeer returns.
+//
+// The core learns the key of a peer during its handshake, and the shaper from
+// the core's peers, which it checks every second. Until then only the limits
+// of the link apply, except to peers with a single pinned key.
+//
+// The core only knows the loopback addresses of the relayed connections, so
+// modules that report on peers list them with GetPeers instead. Anything that
+// connects to the core's listener itself isn't limited, and link-local peers
+// are subject to AllowedPublicKeys, as the core can't tell them apart.
+type Shaper struct {
+	core      *core.Core
+	log       *logging.Logger
+	config    popura.ShapingConfig
+	keys      map[string]*limits // by public key in hex
+	listeners []net.Listener
+	backends  []*core.Listener
+	links     map[string]*link  // by address of the core's peer
+	outbound  map[string]string // URIs of relays by URI of peer
+	ctx       context.Context   // cancelled by stop
+	cancel    context.CancelFunc
+	done      chan struct{}
+	wg        sync.WaitGroup
+	lock      sync.Mutex
+}
+
+func (s *Shaper) Init(yggcore *core.Core, yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig, log *logging.Logger, options interface{}) error {
+	s.core = yggcore
+	s.log = log
+	s.config = popConfig.Shaping
+	if !s.config.Enable {
+		return nil
+	}
+	for _, listen := range s.config.Listen {
+		if _, err := parseListen(listen); err != nil {
+			return err
+		}
+	}
+	s.keys = make(map[string]*limits)
+	for key, l := range s.config.Keys {
+		if b, err := hex.DecodeString(key); err != nil || len(b) != 32 {
+			return fmt.Errorf("Keys: %q is not a public key in hex", key)
+		}
+		s.keys[strings.ToLower(key)] = &limits{up: newBucket(l.Upload), down: newBucket(l.Download)}
+	}
+	return nil
+}
+
+// parseListen parses a URI of Popura.Shaping.Listen, which may only be a TCP
+// or TLS address.
+func parseListen(listen string) (*url.URL, error) {
+	u, err := url.Parse(listen)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "tcp" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("Listen: %q must be a tcp:// or tls:// address", listen)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, fmt.Errorf("Listen: %q: %w", listen, err)
+	}
+	return u, nil
+}
+
+func (s *Shaper) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.config.Enable {
+		return nil
+	}
+	if s.done != nil {
+		return errors.New("already started")
+	}
+	s.done = make(chan struct{})
+	s.links = make(map[string]*link)
+	s.outbound = make(map[string]string)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, listen := range s.config.Listen {
+		u, _ := parseListen(listen)
+		backend, err := s.core.Listen(&url.URL{Scheme: u.Scheme, Host: "127.0.0.1:0", RawQuery: u.RawQuery}, "")
+		if err != nil {
+			s.stop()
+			return err
+		}
+		s.backends = append(s.backends, backend)
+		listener, err := net.Listen("tcp", u.Host)
+		if err != nil {
+			s.stop()
+			return err
+		}
+		s.listeners = append(s.listeners, listener)
+		s.wg.Add(1)
+		go s.accept(u.Scheme, listener, backend.Addr().String())
+		s.log.Infof("shaping: accepting peers on %s://%s", u.Scheme, listener.Addr())
+	}
+	s.wg.Add(1)
+	go s.identifyLoop(s.done)
+	return nil
+}
+
+func (s *Shaper) Stop() error {
+	s.lock.Lock()
+	s.stop()
+	backends := s.backends
+	s.backends = nil
+	s.lock.Unlock()
+	s.wg.Wait()
+	for _, backend := range backends {
+		_ = backend.Close()
+	}
+	return nil
+}
+
+// stop closes the listeners and links. lock must be held.
+func (s *Shaper) stop() {
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+		s.cancel()
+	}
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	s.listeners = nil
+	for _, l := range s.links {
+		l.peer.Close()
+		l.backend.Close()
+	}
+}
+
+func (s *Shaper) UpdateConfig(yggConfig *config.NodeConfig, popConfig *popura.PopuraConfig) {}
+
+func (s *Shaper) IsStarted() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.done != nil
+}
+
+func (s *Shaper) Health() error {
+	if s.config.Enable && !s.IsStarted() {
+		return errors.New("shaping is not running")
+	}
+	return nil
+}
+
+func (s *Shaper) accept(scheme string, listener net.Listener, backend string) {
+	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		s.wg.Add(1)
+		go s.relay(scheme, conn, backend)
+	}
+}
+
+// relay copies the traffic of a peer to and from the core until either side
+// closes its connection.
+func (s *Shaper) relay(scheme string, peer net.Conn, backend string) {
+	defer s.wg.Done()
+	defer peer.Close()
+	conn, err := net.DialTimeout("tcp", backend, 5*time.Second)
+	if err != nil {
+		s.log.Errorln("shaping: failed to connect to the core:", err)
+		return
+	}
+	defer conn.Close()
+	l := &link{
+		remote:  scheme + "://" + peer.RemoteAddr().String(),
+		local:   conn.LocalAddr().String(),
+		limits:  limits{up: newBucket(s.config.Upload), down: newBucket(s.config.Download)},
+		peer:    peer,
+		backend: conn,
+	}
+	s.log.Infof("shaping: relaying %s to the core from %s", l.remote, l.local)
+	s.shape(l)
+}
+
+// shape copies the traffic of l between its peer and the core until either
+// side closes its connection.
+func (s *Shaper) shape(l *link) {
+	s.lock.Lock()
+	if s.done == nil {
+		s.lock.Unlock()
+		return
+	}
+	done := s.done
+	s.links[l.local] = l
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		if s.links[l.local] == l {
+			delete(s.links, l.local)
+		}
+		s.lock.Unlock()
+	}()
+
+	finished := make(chan struct{}, 2)
+	go func() {
+		s.copy(l.backend, l.peer, l, false, done)
+		l.backend.Close()
+		finished <- struct{}{}
+	}()
+	go func() {
+		s.copy(l.peer, l.backend, l, true, done)
+		l.peer.Close()
+		finished <- struct{}{}
+	}()
+	<-finished
+	<-finished
+}
+
+// copy copies from src to dst, waiting for the limits of l in the direction
+// given by up before writing.
+func (s *Shaper) copy(dst io.Writer, src io.Reader, l *link, up bool, done chan struct{}) {
+	buf := make([]byte, relayBuffer)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if !s.wait(l, up, n, done) {
+				return
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wait waits until n bytes may pass over l, and reports whether the shaper is
+// still running.
+func (s *Shaper) wait(l *link, up bool, n int, done chan struct{}) bool {
+	s.lock.Lock()
+	keyLims := l.keyLims
+	s.lock.Unlock()
+	var wait time.Duration
+	for _, lims := range []*limits{&l.limits, keyLims} {
+		if lims == nil {
+			continue
+		}
+		b := lims.down
+		if up {
+			b = lims.up
+		}
+		if w := b.take(n); w > wait {
+			wait = w
+		}
+	}
+	if wait == 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+func (s *Shaper) identifyLoop(done chan struct{}) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(identifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.identify(s.core.GetPeers())
+		}
+	}
+}
+
+// identify learns the public keys of the peers of links from the peers of
+// the core, whose remote addresses are those of the connections of the links
+// to it.
+func (s *Shaper) identify(peers []core.PeerInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, p := range peers {
+		l := s.linkFor(p.Remote)
+		if l == nil || l.key != "" {
+			continue
+		}
+		l.key = hex.EncodeToString(p.Key)
+		l.keyLims = s.keys[l.key]
+	}
+}
+
+// GetPeers returns the peers of the core, with the remote addresses of the
+// peers of links rather than those of the links' connections to the core.
+func (s *Shaper) GetPeers() []core.PeerInfo {
+	peers := s.core.GetPeers()
+	if s.config.Enable {
+		s.rename(peers)
+	}
+	return peers
+}
+
+// rename identifies the peers of links, and gives them the remote addresses
+// of the links' peers. It returns the link of each peer, or nil if it has
+// none.
+func (s *Shaper) rename(peers []core.PeerInfo) []*link {
+	s.identify(peers)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	links := make([]*link, len(peers))
+	for i := range peers {
+		if l := s.linkFor(peers[i].Remote); l != nil {
+			links[i] = l
+			peers[i].Remote = l.remote
+		}
+	}
+	return links
+}
+
+// linkFor returns the link whose connection to the core the core names
+// remote, or nil if it isn't one. lock must be held.
+func (s *Shaper) linkFor(remote string) *link {
+	if i := strings.Index(remote, "://"); i >= 0 {
+		remote = remote[i+len("://"):]
+	}
+	return s.links[remote]
+}
@@ -0,0 +1,169 @@
+package shaping
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/core"
+
+	"github.com/popura-network/Popura/src/logging"
+	"github.com/popura-network/Popura/src/popura"
+)
+
+func TestBucketTake(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  uint64
+		takes []int
+		wait  time.Duration // of the last take
+	}{
+		{"no limit", 0, []int{1 << 30}, 0},
+		{"within burst", 100000, []int{50000}, 0},
+		{"whole burst", 100000, []int{100000}, 0},
+		{"burst then more", 100000, []int{100000, 50000}, 500 * time.Millisecond},
+		{"more than burst", 100000, []int{150000}, 500 * time.Millisecond},
+		{"waits add up", 100000, []int{150000, 50000}, time.Second},
+		{"least burst", 1000, []int{minBurst, 1000}, time.Second},
+	}
+	const slack = 20 * time.Millisecond
+	for _, test := range tests {
+		b := newBucket(test.rate)
+		var wait time.Duration
+		for _, n := range test.takes {
+			wait = b.take(n)
+		}
+		if wait > test.wait || wait < test.wait-slack {
+			t.Errorf("%s: got a wait of %s, want %s", test.name, wait, test.wait)
+		}
+		throttled, delayed := b.state()
+		if throttled != (test.wait > 0) {
+			t.Errorf("%s: got throttled %v", test.name, throttled)
+		}
+		if test.wait > 0 && delayed < wait {
+			t.Errorf("%s: got %s delayed in total, want at least %s", test.name, delayed, wait)
+		}
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	limited := bytes.Repeat([]byte{1}, 32)
+	other := bytes.Repeat([]byte{2}, 32)
+	local := bytes.Repeat([]byte{3}, 32)
+	keyLims := &limits{up: newBucket(1000), down: newBucket(1000)}
+	tcp := &link{remote: "tcp://192.0.2.1:40000", local: "127.0.0.1:50000"}
+	tls := &link{remote: "tls://[2001:db8::1]:40001", local: "127.0.0.1:50001"}
+	idle := &link{remote: "tcp://192.0.2.2:40002", local: "127.0.0.1:50002"}
+	s := &Shaper{
+		keys: map[string]*limits{hex.EncodeToString(limited): keyLims},
+		links: map[string]*link{
+			tcp.local:  tcp,
+			tls.local:  tls,
+			idle.local: idle,
+		},
+	}
+	peers := []core.PeerInfo{
+		{Key: limited, Remote: "tcp://127.0.0.1:50000"},
+		{Key: other, Remote: "tls://127.0.0.1:50001"},
+		{Key: local, Remote: "tcp://127.0.0.1:50003"},
+		{Key: local, Remote: "tls://[fe80::1%eth0]:40003"},
+	}
+	links := s.rename(peers)
+
+	tests := []struct {
+		link    *link
+		remote  string
+		key     []byte
+		keyLims *limits
+	}{
+		{tcp, "tcp://192.0.2.1:40000", limited, keyLims},
+		{tls, "tls://[2001:db8::1]:40001", other, nil},
+		{nil, "tcp://127.0.0.1:50003", nil, nil},
+		{nil, "tls://[fe80::1%eth0]:40003", nil, nil},
+	}
+	for i, test := range tests {
+		if links[i] != test.link {
+			t.Errorf("peer %d: got link %v, want %v", i, links[i], test.link)
+		}
+		if peers[i].Remote != test.remote {
+			t.Errorf("peer %d: got remote %q, want %q", i, peers[i].Remote, test.remote)
+		}
+		if test.link == nil {
+			continue
+		}
+		if test.link.key != hex.EncodeToString(test.key) {
+			t.Errorf("peer %d: got key %s", i, test.link.key)
+		}
+		if test.link.keyLims != test.keyLims {
+			t.Errorf("peer %d: got the limits of key %s wrong", i, test.link.key)
+		}
+	}
+	if idle.key != "" {
+		t.Errorf("a link without a peer got key %s", idle.key)
+	}
+
+	// The key of a link doesn't change once known.
+	s.identify([]core.PeerInfo{{Key: other, Remote: "tcp://127.0.0.1:50000"}})
+	if tcp.key != hex.EncodeToString(limited) {
+		t.Errorf("the key of a link changed to %s", tcp.key)
+	}
+	if l := s.linkFor("127.0.0.1:50001"); l != tls {
+		t.Errorf("linkFor without a scheme got %v", l)
+	}
+}
+
+func TestRelayPeer(t *testing.T) {
+	key := hex.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	s := &Shaper{
+		log:      logging.New(io.Discard, "text").Logger("shaping"),
+		config:   popura.ShapingConfig{Enable: true},
+		done:     make(chan struct{}),
+		outbound: make(map[string]string),
+		ctx:      context.Background(),
+		cancel:   func() {},
+	}
+	defer s.Stop()
+
+	tests := []struct {
+		uri    string
+		scheme string
+		query  url.Values
+	}{
+		{"tcp://192.0.2.1:1000", "tcp", url.Values{}},
+		{"tls://example.com:443?key=" + key, "tls", url.Values{"key": {key}, "sni": {"example.com"}}},
+		{"tls://[2001:db8::1]:443", "tls", url.Values{}},
+		{"tls://192.0.2.1:443?sni=example.com", "tls", url.Values{"sni": {"example.com"}}},
+		{"socks://127.0.0.1:9050/example.com:1000", "socks", url.Values{}},
+	}
+	for _, test := range tests {
+		relay, err := s.RelayPeer(test.uri)
+		if err != nil {
+			t.Fatalf("%s: %v", test.uri, err)
+		}
+		u, err := url.Parse(relay)
+		if err != nil {
+			t.Fatalf("%s: %v", test.uri, err)
+		}
+		if host, _, _ := net.SplitHostPort(u.Host); u.Scheme != test.scheme || host != "127.0.0.1" {
+			t.Errorf("%s: got relay %s", test.uri, relay)
+		}
+		if !reflect.DeepEqual(u.Query(), test.query) {
+			t.Errorf("%s: got query %v, want %v", test.uri, u.Query(), test.query)
+		}
+		if again, _ := s.RelayPeer(test.uri); again != relay {
+			t.Errorf("%s: got relay %s, then %s", test.uri, relay, again)
+		}
+	}
+	if relay, err := s.RelayPeer("unix:///run/peer.sock"); err != nil || relay != "unix:///run/peer.sock" {
+		t.Errorf("got relay %s and error %v for a unix peer", relay, err)
+	}
+	if _, err := s.RelayPeer("tcp://192.0.2.1"); err == nil {
+		t.Error("relayed a peer without a port")
+	}
+}
@@ -0,0 +1,111 @@
+package shaping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const dialTimeout = 10 * time.Second
+
+// RelayPeer returns the URI that the core should connect to instead of the
+// peer uri for the link to be limited: that of a relay listener on the
+// loopback interface, which connects each connection from the core on to the
+// peer. The relay of a peer is reused and lasts until the shaper is stopped.
+// Peers that can't be relayed, such as unix:// ones, and all peers when
+// shaping is disabled are returned unchanged.
+func (s *Shaper) RelayPeer(uri string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.config.Enable {
+		return uri, nil
+	}
+	if s.done == nil {
+		return "", errors.New("shaping is not running")
+	}
+	if relay, ok := s.outbound[uri]; ok {
+		return relay, nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "tcp" && u.Scheme != "tls" && u.Scheme != "socks" {
+		return uri, nil
+	}
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return "", fmt.Errorf("%q: %w", uri, err)
+	}
+	// A peer pinned to a single key is limited by it from the start.
+	var key string
+	if keys := u.Query()["key"]; len(keys) == 1 {
+		key = strings.ToLower(keys[0])
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	relay := *u
+	relay.Host = listener.Addr().String()
+	// The core only takes the name of the peer for TLS from the host of the
+	// URI, which is now the relay's, or from sni.
+	if query := u.Query(); u.Scheme == "tls" && query.Get("sni") == "" && net.ParseIP(host) == nil {
+		query.Set("sni", host)
+		relay.RawQuery = query.Encode()
+	}
+	s.listeners = append(s.listeners, listener)
+	s.outbound[uri] = relay.String()
+	s.wg.Add(1)
+	go s.acceptOutbound(s.ctx, u.Scheme, u.Host, key, listener)
+	s.log.Infof("shaping: relaying %s://%s through %s", u.Scheme, u.Host, listener.Addr())
+	return relay.String(), nil
+}
+
+func (s *Shaper) acceptOutbound(ctx context.Context, scheme, peer, key string, listener net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		s.wg.Add(1)
+		go s.relayOutbound(ctx, scheme, conn, peer, key)
+	}
+}
+
+// relayOutbound connects a connection from the core on to the peer at
+// address, and copies the traffic between them until either side closes its
+// connection. Stopping the shaper cancels ctx.
+func (s *Shaper) relayOutbound(ctx context.Context, scheme string, conn net.Conn, address, key string) {
+	defer s.wg.Done()
+	defer conn.Close()
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	peer, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		s.log.Warnf("shaping: failed to connect to %s://%s: %v", scheme, address, err)
+		return
+	}
+	defer peer.Close()
+	l := &link{
+		remote:  scheme + "://" + peer.RemoteAddr().String(),
+		local:   conn.LocalAddr().String(),
+		limits:  limits{up: newBucket(s.config.Upload), down: newBucket(s.config.Download)},
+		peer:    peer,
+		backend: conn,
+	}
+	if key != "" {
+		l.key = key
+		l.keyLims = s.keys[key]
+	}
+	s.log.Infof("shaping: relaying %s from the core on %s", l.remote, l.local)
+	s.shape(l)
+}